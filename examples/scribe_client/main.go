@@ -15,7 +15,7 @@ func main() {
 	}
 
 	t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, 0), thrift.BinaryProtocol)
-	client := thrift.NewClient(t, false)
+	client := thrift.NewNetRPCClient(t, false)
 	scr := scribe.ScribeClient{Client: client}
 	res, err := scr.Log([]*scribe.LogEntry{{"category", "message"}})
 	if err != nil {
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
-	"net/rpc"
+	"os"
+	"os/signal"
 
 	"github.com/samuel/go-thrift/examples/scribe"
 	"github.com/samuel/go-thrift/thrift"
@@ -22,20 +24,22 @@ func (s *scribeServiceImplementation) Log(messages []*scribe.LogEntry) (scribe.R
 
 func main() {
 	scribeService := new(scribeServiceImplementation)
-	rpc.RegisterName("Thrift", &scribe.ScribeServer{Implementation: scribeService})
+	server := thrift.NewServer()
+	server.Register(&scribe.ScribeServer{Implementation: scribeService})
 
 	ln, err := net.Listen("tcp", ":1463")
 	if err != nil {
 		panic(err)
 	}
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			fmt.Printf("ERROR: %+v\n", err)
-			continue
-		}
-		fmt.Printf("New connection %+v\n", conn)
-		t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, 0), thrift.BinaryProtocol)
-		go rpc.ServeCodec(thrift.NewServerCodec(t))
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		server.Shutdown(context.Background())
+	}()
+
+	if err := server.Serve(ln); err != thrift.ErrServerClosed {
+		panic(err)
 	}
 }
@@ -27,6 +27,7 @@ var (
 	flagGoJSONEnumnum  = flag.Bool("go.json.enumnum", false, "For JSON marshal enums by number instead of name")
 	flagGoPointers     = flag.Bool("go.pointers", false, "Make all fields pointers")
 	flagGoImportPrefix = flag.String("go.importprefix", "", "Prefix for thrift-generated go package imports")
+	flagGoCodec        = flag.Bool("go.codec", false, "Generate EncodeThrift and DecodeThrift methods for structs")
 )
 
 var (
@@ -58,6 +59,9 @@ type GoGenerator struct {
 	Packages    map[string]GoPackage
 	Format      bool
 	Pointers    bool
+	Codec       bool
+
+	codecVars int
 }
 
 var goKeywords = map[string]bool{
@@ -111,6 +115,17 @@ func validGoIdent(id string) string {
 	return id
 }
 
+// isIdempotent returns true if the method is annotated as safe to retry
+// with (idempotent = "true").
+func isIdempotent(m *parser.Method) bool {
+	for _, a := range m.Annotations {
+		if a.Name == "idempotent" && a.Value == "true" {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *GoGenerator) error(err error) {
 	panic(err)
 }
@@ -432,7 +447,12 @@ func (g *GoGenerator) writeStruct(out io.Writer, st *parser.Struct) error {
 	for _, field := range st.Fields {
 		g.write(out, "\t%s\n", g.formatField(field))
 	}
-	return g.write(out, "}\n")
+	g.write(out, "}\n")
+
+	if g.Codec {
+		g.writeStructCodec(out, st)
+	}
+	return nil
 }
 
 func (g *GoGenerator) writeException(out io.Writer, ex *parser.Struct) error {
@@ -490,7 +510,8 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 	for _, k := range methodNames {
 		method := svc.Methods[k]
 		mName := camelCase(method.Name)
-		resArg := ""
+		// net/rpc requires a response argument even for one-way methods
+		resArg := ", _ *struct{}"
 		if !method.Oneway {
 			resArg = fmt.Sprintf(", res *%s%sResponse", svcName, mName)
 		}
@@ -531,6 +552,9 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 			return err
 		}
 
+		if isIdempotent(method) {
+			g.write(out, "\nfunc (r *%s) Idempotent() bool {\n\treturn true\n}\n", reqStructName)
+		}
 		if method.Oneway {
 			g.write(out, "\nfunc (r *%s) Oneway() bool {\n\treturn true\n}\n", reqStructName)
 		} else {
@@ -558,7 +582,7 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 	for _, k := range methodNames {
 		method := svc.Methods[k]
 		methodName := camelCase(method.Name)
-		returnType := "err error"
+		returnType := "(err error)"
 		if !method.Oneway {
 			returnType = g.formatReturnType(method.ReturnType, true)
 		}
@@ -622,6 +646,9 @@ func (g *GoGenerator) generateSingle(out io.Writer, thriftPath string, thrift *p
 	if len(thrift.Enums) > 0 {
 		imports = append(imports, "strconv")
 	}
+	if g.Codec {
+		imports = append(imports, "reflect", "github.com/samuel/go-thrift/thrift")
+	}
 	if len(thrift.Includes) > 0 {
 		for _, path := range thrift.Includes {
 			pkg := g.Packages[path].Name
@@ -643,6 +670,9 @@ func (g *GoGenerator) generateSingle(out io.Writer, thriftPath string, thrift *p
 	}
 
 	g.write(out, "\nvar _ = fmt.Sprintf\n")
+	if g.Codec {
+		g.write(out, "var _ = reflect.ValueOf\nvar _ = thrift.SkipValue\n")
+	}
 
 	if len(thrift.Typedefs) > 0 {
 		g.write(out, "\n")
@@ -0,0 +1,356 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/samuel/go-thrift/parser"
+)
+
+// codecScalars maps base thrift types to the ProtocolReader/Writer method
+// suffix and the Go type they use.
+var codecScalars = map[string][2]string{
+	"bool":   {"Bool", "bool"},
+	"byte":   {"Byte", "byte"},
+	"i16":    {"I16", "int16"},
+	"i32":    {"I32", "int32"},
+	"i64":    {"I64", "int64"},
+	"double": {"Double", "float64"},
+	"string": {"String", "string"},
+	"binary": {"Bytes", "[]byte"},
+}
+
+type fieldsByID []*parser.Field
+
+func (f fieldsByID) Len() int           { return len(f) }
+func (f fieldsByID) Less(i, j int) bool { return f[i].ID < f[j].ID }
+func (f fieldsByID) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// codecType follows includes and typedefs to the type that's actually
+// encoded. It returns the package and file the type is defined in since
+// the elements of containers are formatted relative to them.
+func (g *GoGenerator) codecType(pkg string, thrift *parser.Thrift, typ *parser.Type) (string, *parser.Thrift, *parser.Type) {
+	for {
+		if strings.Contains(typ.Name, ".") {
+			parts := strings.SplitN(typ.Name, ".", 2)
+			thriftFilename := thrift.Includes[parts[0]]
+			if thriftFilename == "" {
+				g.error(ErrMissingInclude(parts[0]))
+			}
+			thrift = g.ThriftFiles[thriftFilename]
+			if thrift == nil {
+				g.error(ErrMissingInclude(thriftFilename))
+			}
+			pkg = g.Packages[thriftFilename].Name
+			typ = &parser.Type{
+				Name:      parts[1],
+				KeyType:   typ.KeyType,
+				ValueType: typ.ValueType,
+			}
+		}
+		t := thrift.Typedefs[typ.Name]
+		if t == nil {
+			return pkg, thrift, typ
+		}
+		typ = t.Type
+	}
+}
+
+// codecScalar returns the method suffix and Go type used to read and write
+// a type that isn't a container or struct. goType is the type of the value
+// in the generated code which decides between string and []byte for binary.
+func (g *GoGenerator) codecScalar(thrift *parser.Thrift, typ *parser.Type, goType string) (string, string, bool) {
+	if typ.Name == "binary" && (*flagGoBinarystring || goType == "string") {
+		return "String", "string", true
+	}
+	if s, ok := codecScalars[typ.Name]; ok {
+		return s[0], s[1], true
+	}
+	if thrift.Enums[typ.Name] != nil {
+		return "I32", "int32", true
+	}
+	return "", "", false
+}
+
+// isCodecBytes returns true for list<byte> which is encoded as binary
+// the same as []byte is by EncodeStruct.
+func (g *GoGenerator) isCodecBytes(pkg string, thrift *parser.Thrift, typ *parser.Type) bool {
+	return typ.Name == "list" && g.formatType(pkg, thrift, typ.ValueType, 0) == "byte"
+}
+
+// codecThriftType returns the name of the thrift type constant for typ.
+func (g *GoGenerator) codecThriftType(pkg string, thrift *parser.Thrift, typ *parser.Type) string {
+	pkg, thrift, typ = g.codecType(pkg, thrift, typ)
+	switch typ.Name {
+	case "bool":
+		return "thrift.TypeBool"
+	case "byte":
+		return "thrift.TypeByte"
+	case "i16":
+		return "thrift.TypeI16"
+	case "i32":
+		return "thrift.TypeI32"
+	case "i64":
+		return "thrift.TypeI64"
+	case "double":
+		return "thrift.TypeDouble"
+	case "string", "binary":
+		return "thrift.TypeString"
+	case "list":
+		if g.isCodecBytes(pkg, thrift, typ) {
+			return "thrift.TypeString"
+		}
+		return "thrift.TypeList"
+	case "set":
+		return "thrift.TypeSet"
+	case "map":
+		return "thrift.TypeMap"
+	}
+	if thrift.Enums[typ.Name] != nil {
+		return "thrift.TypeI32"
+	}
+	return "thrift.TypeStruct"
+}
+
+func (g *GoGenerator) codecVar(prefix string) string {
+	g.codecVars++
+	return fmt.Sprintf("%s%d", prefix, g.codecVars)
+}
+
+// writeCodecCall writes a call to a method that only returns an error.
+func (g *GoGenerator) writeCodecCall(out io.Writer, indent, call string, a ...interface{}) {
+	g.write(out, "%sif err := %s; err != nil {\n%s\treturn err\n%s}\n", indent, fmt.Sprintf(call, a...), indent, indent)
+}
+
+// codecConvert returns an expression converting the variable src of Go
+// type srcType to goType. A temporary is declared if a pointer is needed.
+func (g *GoGenerator) codecConvert(out io.Writer, indent, goType, src, srcType string) string {
+	switch {
+	case goType == srcType:
+		return src
+	case goType == "*"+srcType:
+		return "&" + src
+	case strings.HasPrefix(goType, "*"):
+		tmp := g.codecVar("t")
+		g.write(out, "%s%s := %s(%s)\n", indent, tmp, goType[1:], src)
+		return "&" + tmp
+	}
+	return fmt.Sprintf("%s(%s)", goType, src)
+}
+
+// writeEncodeValue writes the code to encode the expression v of Go type
+// goType using the ProtocolWriter w.
+func (g *GoGenerator) writeEncodeValue(out io.Writer, indent string, pkg string, thrift *parser.Thrift, typ *parser.Type, goType, v string) {
+	pkg, thrift, typ = g.codecType(pkg, thrift, typ)
+
+	if g.codecThriftType(pkg, thrift, typ) == "thrift.TypeStruct" {
+		g.writeCodecCall(out, indent, "thrift.EncodeStruct(w, %s)", v)
+		return
+	}
+	if strings.HasPrefix(goType, "*") {
+		v = "*" + v
+		goType = goType[1:]
+	}
+
+	if method, scalarType, ok := g.codecScalar(thrift, typ, goType); ok {
+		if goType != scalarType {
+			v = fmt.Sprintf("%s(%s)", scalarType, v)
+		}
+		g.writeCodecCall(out, indent, "w.Write%s(%s)", method, v)
+		return
+	}
+
+	switch typ.Name {
+	case "list":
+		if g.isCodecBytes(pkg, thrift, typ) {
+			g.writeCodecCall(out, indent, "w.WriteBytes([]byte(%s))", v)
+			return
+		}
+		elem := g.codecVar("e")
+		g.writeCodecCall(out, indent, "w.WriteListBegin(%s, len(%s))", g.codecThriftType(pkg, thrift, typ.ValueType), v)
+		g.write(out, "%sfor _, %s := range %s {\n", indent, elem, v)
+		g.writeEncodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, g.formatType(pkg, thrift, typ.ValueType, 0), elem)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "w.WriteListEnd()")
+	case "set":
+		elem := g.codecVar("e")
+		g.writeCodecCall(out, indent, "w.WriteSetBegin(%s, len(%s))", g.codecThriftType(pkg, thrift, typ.ValueType), v)
+		g.write(out, "%sfor %s := range %s {\n", indent, elem, v)
+		g.writeEncodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, g.formatKeyType(pkg, thrift, typ.ValueType), elem)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "w.WriteSetEnd()")
+	case "map":
+		key := g.codecVar("k")
+		val := g.codecVar("v")
+		g.writeCodecCall(out, indent, "w.WriteMapBegin(%s, %s, len(%s))",
+			g.codecThriftType(pkg, thrift, typ.KeyType), g.codecThriftType(pkg, thrift, typ.ValueType), v)
+		g.write(out, "%sfor %s, %s := range %s {\n", indent, key, val, v)
+		g.writeEncodeValue(out, indent+"\t", pkg, thrift, typ.KeyType, g.formatKeyType(pkg, thrift, typ.KeyType), key)
+		g.writeEncodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, g.formatType(pkg, thrift, typ.ValueType, toNoPointer), val)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "w.WriteMapEnd()")
+	default:
+		g.error(ErrUnknownType(typ.Name))
+	}
+}
+
+// writeDecodeValue writes the code to decode a value of Go type goType
+// using the ProtocolReader r. It returns an expression for the value.
+func (g *GoGenerator) writeDecodeValue(out io.Writer, indent string, pkg string, thrift *parser.Thrift, typ *parser.Type, goType string) string {
+	pkg, thrift, typ = g.codecType(pkg, thrift, typ)
+
+	if g.codecThriftType(pkg, thrift, typ) == "thrift.TypeStruct" {
+		structType := g.formatType(pkg, thrift, typ, 0)
+		val := g.codecVar("v")
+		g.write(out, "%s%s := &%s{}\n", indent, val, structType[1:])
+		g.writeCodecCall(out, indent, "thrift.DecodeStruct(r, %s)", val)
+		return g.codecConvert(out, indent, goType, val, structType)
+	}
+	valueType := strings.TrimPrefix(goType, "*")
+
+	if method, scalarType, ok := g.codecScalar(thrift, typ, valueType); ok {
+		val := g.codecVar("v")
+		g.write(out, "%s%s, err := r.Read%s()\n", indent, val, method)
+		g.write(out, "%sif err != nil {\n%s\treturn err\n%s}\n", indent, indent, indent)
+		return g.codecConvert(out, indent, goType, val, scalarType)
+	}
+
+	val := g.codecVar("v")
+	switch typ.Name {
+	case "list":
+		if g.isCodecBytes(pkg, thrift, typ) {
+			g.write(out, "%s%s, err := r.ReadBytes()\n", indent, val)
+			g.write(out, "%sif err != nil {\n%s\treturn err\n%s}\n", indent, indent, indent)
+			return g.codecConvert(out, indent, goType, val, "[]byte")
+		}
+		n := g.codecVar("n")
+		elemType := g.formatType(pkg, thrift, typ.ValueType, 0)
+		g.write(out, "%s_, %s, err := r.ReadListBegin()\n", indent, n)
+		g.write(out, "%sif err != nil {\n%s\treturn err\n%s}\n", indent, indent, indent)
+		g.write(out, "%svar %s %s\n", indent, val, valueType)
+		g.write(out, "%sfor i := 0; i < %s; i++ {\n", indent, n)
+		elem := g.writeDecodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, elemType)
+		g.write(out, "%s\t%s = append(%s, %s)\n", indent, val, val, elem)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "r.ReadListEnd()")
+	case "set":
+		n := g.codecVar("n")
+		elemType := g.formatKeyType(pkg, thrift, typ.ValueType)
+		g.write(out, "%s_, %s, err := r.ReadSetBegin()\n", indent, n)
+		g.write(out, "%sif err != nil {\n%s\treturn err\n%s}\n", indent, indent, indent)
+		g.write(out, "%s%s := make(%s, %s)\n", indent, val, valueType, n)
+		g.write(out, "%sfor i := 0; i < %s; i++ {\n", indent, n)
+		elem := g.writeDecodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, elemType)
+		g.write(out, "%s\t%s[%s] = struct{}{}\n", indent, val, elem)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "r.ReadSetEnd()")
+	case "map":
+		n := g.codecVar("n")
+		keyType := g.formatKeyType(pkg, thrift, typ.KeyType)
+		elemType := g.formatType(pkg, thrift, typ.ValueType, toNoPointer)
+		g.write(out, "%s_, _, %s, err := r.ReadMapBegin()\n", indent, n)
+		g.write(out, "%sif err != nil {\n%s\treturn err\n%s}\n", indent, indent, indent)
+		g.write(out, "%s%s := make(%s, %s)\n", indent, val, valueType, n)
+		g.write(out, "%sfor i := 0; i < %s; i++ {\n", indent, n)
+		key := g.writeDecodeValue(out, indent+"\t", pkg, thrift, typ.KeyType, keyType)
+		elem := g.writeDecodeValue(out, indent+"\t", pkg, thrift, typ.ValueType, elemType)
+		g.write(out, "%s\t%s[%s] = %s\n", indent, val, key, elem)
+		g.write(out, "%s}\n", indent)
+		g.writeCodecCall(out, indent, "r.ReadMapEnd()")
+	default:
+		g.error(ErrUnknownType(typ.Name))
+	}
+	return g.codecConvert(out, indent, goType, val, valueType)
+}
+
+// writeStructCodec writes EncodeThrift and DecodeThrift methods for a
+// struct which produce the same output as EncodeStruct and DecodeStruct
+// without the use of reflection.
+func (g *GoGenerator) writeStructCodec(out io.Writer, st *parser.Struct) {
+	structName := camelCase(st.Name)
+	fields := make([]*parser.Field, len(st.Fields))
+	copy(fields, st.Fields)
+	sort.Sort(fieldsByID(fields))
+
+	// EncodeThrift
+
+	g.codecVars = 0
+	g.write(out, "\nfunc (s *%s) EncodeThrift(w thrift.ProtocolWriter) error {\n", structName)
+	g.writeCodecCall(out, "\t", "w.WriteStructBegin(%q)", structName)
+	for _, field := range fields {
+		var opt typeOption
+		if field.Optional {
+			opt |= toOptional
+		}
+		fieldName := camelCase(field.Name)
+		goType := g.formatType(g.pkg, g.thrift, field.Type, opt)
+		indent := "\t"
+		if field.Optional {
+			g.write(out, "\tif s.%s != nil {\n", fieldName)
+			indent = "\t\t"
+		} else if strings.HasPrefix(goType, "*") {
+			g.write(out, "\tif s.%s == nil {\n\t\treturn &thrift.MissingRequiredField{StructName: %q, FieldName: %q}\n\t}\n",
+				fieldName, structName, fieldName)
+		}
+		g.writeCodecCall(out, indent, "w.WriteFieldBegin(%q, %s, %d)",
+			fieldName, g.codecThriftType(g.pkg, g.thrift, field.Type), field.ID)
+		g.writeEncodeValue(out, indent, g.pkg, g.thrift, field.Type, goType, "s."+fieldName)
+		g.writeCodecCall(out, indent, "w.WriteFieldEnd()")
+		if field.Optional {
+			g.write(out, "\t}\n")
+		}
+	}
+	g.writeCodecCall(out, "\t", "w.WriteFieldStop()")
+	g.writeCodecCall(out, "\t", "w.WriteStructEnd()")
+	g.write(out, "\treturn nil\n}\n")
+
+	// DecodeThrift
+
+	g.codecVars = 0
+	g.write(out, "\nfunc (s *%s) DecodeThrift(r thrift.ProtocolReader) error {\n", structName)
+	g.writeCodecCall(out, "\t", "r.ReadStructBegin()")
+	for _, field := range fields {
+		if !field.Optional {
+			g.write(out, "\tisset%s := false\n", camelCase(field.Name))
+		}
+	}
+	g.write(out, "\tfor {\n\t\tftype, id, err := r.ReadFieldBegin()\n\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n")
+	g.write(out, "\t\tif ftype == thrift.TypeStop {\n\t\t\tbreak\n\t\t}\n")
+	g.write(out, "\t\tswitch id {\n")
+	for _, field := range fields {
+		var opt typeOption
+		if field.Optional {
+			opt |= toOptional
+		}
+		fieldName := camelCase(field.Name)
+		goType := g.formatType(g.pkg, g.thrift, field.Type, opt)
+		g.write(out, "\t\tcase %d:\n", field.ID)
+		if !field.Optional {
+			g.write(out, "\t\t\tisset%s = true\n", fieldName)
+		}
+		g.write(out, "\t\t\tif ftype != %s {\n", g.codecThriftType(g.pkg, g.thrift, field.Type))
+		g.write(out, "\t\t\t\treturn &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.%s), Str: \"type mismatch\"}\n\t\t\t}\n", fieldName)
+		v := g.writeDecodeValue(out, "\t\t\t", g.pkg, g.thrift, field.Type, goType)
+		g.write(out, "\t\t\ts.%s = %s\n", fieldName, v)
+	}
+	g.write(out, "\t\tdefault:\n")
+	g.writeCodecCall(out, "\t\t\t", "thrift.SkipValue(r, ftype)")
+	g.write(out, "\t\t}\n")
+	g.writeCodecCall(out, "\t\t", "r.ReadFieldEnd()")
+	g.write(out, "\t}\n")
+	g.writeCodecCall(out, "\t", "r.ReadStructEnd()")
+	for _, field := range fields {
+		if !field.Optional {
+			fieldName := camelCase(field.Name)
+			g.write(out, "\tif !isset%s {\n\t\treturn &thrift.MissingRequiredField{StructName: %q, FieldName: %q}\n\t}\n",
+				fieldName, structName, fieldName)
+		}
+	}
+	g.write(out, "\treturn nil\n}\n")
+}
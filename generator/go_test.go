@@ -47,6 +47,32 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestCodec(t *testing.T) {
+	fn := "../testfiles/generator/codec/codec.thrift"
+
+	outPath, err := ioutil.TempDir("", "go-thrift-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outPath)
+
+	p := &parser.Parser{}
+	th, _, err := p.ParseFile(fn)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", fn, err)
+	}
+	generator := &GoGenerator{
+		ThriftFiles: th,
+		Format:      true,
+		Pointers:    true,
+		Codec:       true,
+	}
+	if err := generator.Generate(outPath); err != nil {
+		t.Fatalf("Failed to generate go for %s: %s", fn, err)
+	}
+	compareFiles(t, outPath+"/gentest/codec.go", "../testfiles/generator/codec/codec.go")
+}
+
 func compareFiles(t *testing.T, actualPath, expectedPath string) {
 	ac, err := ioutil.ReadFile(actualPath)
 	if err != nil {
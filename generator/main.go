@@ -89,6 +89,7 @@ func main() {
 	generator := &GoGenerator{
 		ThriftFiles: parsedThrift,
 		Format:      true,
+		Codec:       *flagGoCodec,
 	}
 	err = generator.Generate(outpath)
 	if err != nil {
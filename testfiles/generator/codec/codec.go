@@ -0,0 +1,729 @@
+// This file is automatically generated. Do not modify.
+
+package gentest
+
+import (
+	"fmt"
+	"github.com/samuel/go-thrift/thrift"
+	"reflect"
+	"strconv"
+)
+
+var _ = fmt.Sprintf
+var _ = reflect.ValueOf
+var _ = thrift.SkipValue
+
+type Blob []byte
+type Count int32
+type Names []*string
+
+type Color int32
+
+const (
+	ColorGreen Color = 2
+	ColorRed   Color = 1
+)
+
+var (
+	ColorByName = map[string]Color{
+		"Color.GREEN": ColorGreen,
+		"Color.RED":   ColorRed,
+	}
+	ColorByValue = map[Color]string{
+		ColorGreen: "Color.GREEN",
+		ColorRed:   "Color.RED",
+	}
+)
+
+func (e Color) String() string {
+	name := ColorByValue[e]
+	if name == "" {
+		name = fmt.Sprintf("Unknown enum value Color(%d)", e)
+	}
+	return name
+}
+
+func (e Color) MarshalJSON() ([]byte, error) {
+	name := ColorByValue[e]
+	if name == "" {
+		name = strconv.Itoa(int(e))
+	}
+	return []byte("\"" + name + "\""), nil
+}
+
+func (e *Color) UnmarshalJSON(b []byte) error {
+	st := string(b)
+	if st[0] == '"' {
+		*e = Color(ColorByName[st[1:len(st)-1]])
+		return nil
+	}
+	i, err := strconv.Atoi(st)
+	*e = Color(i)
+	return err
+}
+
+type Point struct {
+	X *int32 `thrift:"1,required" json:"x"`
+	Y *int32 `thrift:"2,required" json:"y"`
+}
+
+func (s *Point) EncodeThrift(w thrift.ProtocolWriter) error {
+	if err := w.WriteStructBegin("Point"); err != nil {
+		return err
+	}
+	if s.X == nil {
+		return &thrift.MissingRequiredField{StructName: "Point", FieldName: "X"}
+	}
+	if err := w.WriteFieldBegin("X", thrift.TypeI32, 1); err != nil {
+		return err
+	}
+	if err := w.WriteI32(*s.X); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Y == nil {
+		return &thrift.MissingRequiredField{StructName: "Point", FieldName: "Y"}
+	}
+	if err := w.WriteFieldBegin("Y", thrift.TypeI32, 2); err != nil {
+		return err
+	}
+	if err := w.WriteI32(*s.Y); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	if err := w.WriteStructEnd(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Point) DecodeThrift(r thrift.ProtocolReader) error {
+	if err := r.ReadStructBegin(); err != nil {
+		return err
+	}
+	issetX := false
+	issetY := false
+	for {
+		ftype, id, err := r.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if ftype == thrift.TypeStop {
+			break
+		}
+		switch id {
+		case 1:
+			issetX = true
+			if ftype != thrift.TypeI32 {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.X), Str: "type mismatch"}
+			}
+			v1, err := r.ReadI32()
+			if err != nil {
+				return err
+			}
+			s.X = &v1
+		case 2:
+			issetY = true
+			if ftype != thrift.TypeI32 {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Y), Str: "type mismatch"}
+			}
+			v2, err := r.ReadI32()
+			if err != nil {
+				return err
+			}
+			s.Y = &v2
+		default:
+			if err := thrift.SkipValue(r, ftype); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := r.ReadStructEnd(); err != nil {
+		return err
+	}
+	if !issetX {
+		return &thrift.MissingRequiredField{StructName: "Point", FieldName: "X"}
+	}
+	if !issetY {
+		return &thrift.MissingRequiredField{StructName: "Point", FieldName: "Y"}
+	}
+	return nil
+}
+
+type Shape struct {
+	Name    *string              `thrift:"3,required" json:"name"`
+	Color   *Color               `thrift:"1" json:"color,omitempty"`
+	Points  []*Point             `thrift:"2,required" json:"points"`
+	Counts  map[string]Count     `thrift:"4" json:"counts,omitempty"`
+	Ids     map[int64]struct{}   `thrift:"5,required" json:"ids"`
+	Data    *Blob                `thrift:"6" json:"data,omitempty"`
+	Tags    *Names               `thrift:"7,required" json:"tags"`
+	Origin  *Point               `thrift:"8" json:"origin,omitempty"`
+	Weights map[Color][]*float64 `thrift:"9,required" json:"weights"`
+	Visible *bool                `thrift:"10,required" json:"visible"`
+	Layer   *int16               `thrift:"11" json:"layer,omitempty"`
+	Flags   *byte                `thrift:"12" json:"flags,omitempty"`
+	Digests map[string]struct{}  `thrift:"13" json:"digests,omitempty"`
+}
+
+func (s *Shape) EncodeThrift(w thrift.ProtocolWriter) error {
+	if err := w.WriteStructBegin("Shape"); err != nil {
+		return err
+	}
+	if s.Color != nil {
+		if err := w.WriteFieldBegin("Color", thrift.TypeI32, 1); err != nil {
+			return err
+		}
+		if err := w.WriteI32(int32(*s.Color)); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteFieldBegin("Points", thrift.TypeList, 2); err != nil {
+		return err
+	}
+	if err := w.WriteListBegin(thrift.TypeStruct, len(s.Points)); err != nil {
+		return err
+	}
+	for _, e1 := range s.Points {
+		if err := thrift.EncodeStruct(w, e1); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteListEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Name == nil {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Name"}
+	}
+	if err := w.WriteFieldBegin("Name", thrift.TypeString, 3); err != nil {
+		return err
+	}
+	if err := w.WriteString(*s.Name); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Counts != nil {
+		if err := w.WriteFieldBegin("Counts", thrift.TypeMap, 4); err != nil {
+			return err
+		}
+		if err := w.WriteMapBegin(thrift.TypeString, thrift.TypeI32, len(s.Counts)); err != nil {
+			return err
+		}
+		for k2, v3 := range s.Counts {
+			if err := w.WriteString(k2); err != nil {
+				return err
+			}
+			if err := w.WriteI32(int32(v3)); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteMapEnd(); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteFieldBegin("Ids", thrift.TypeSet, 5); err != nil {
+		return err
+	}
+	if err := w.WriteSetBegin(thrift.TypeI64, len(s.Ids)); err != nil {
+		return err
+	}
+	for e4 := range s.Ids {
+		if err := w.WriteI64(e4); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteSetEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Data != nil {
+		if err := w.WriteFieldBegin("Data", thrift.TypeString, 6); err != nil {
+			return err
+		}
+		if err := w.WriteBytes([]byte(*s.Data)); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if s.Tags == nil {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Tags"}
+	}
+	if err := w.WriteFieldBegin("Tags", thrift.TypeList, 7); err != nil {
+		return err
+	}
+	if err := w.WriteListBegin(thrift.TypeString, len(*s.Tags)); err != nil {
+		return err
+	}
+	for _, e5 := range *s.Tags {
+		if err := w.WriteString(*e5); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteListEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Origin != nil {
+		if err := w.WriteFieldBegin("Origin", thrift.TypeStruct, 8); err != nil {
+			return err
+		}
+		if err := thrift.EncodeStruct(w, s.Origin); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteFieldBegin("Weights", thrift.TypeMap, 9); err != nil {
+		return err
+	}
+	if err := w.WriteMapBegin(thrift.TypeI32, thrift.TypeList, len(s.Weights)); err != nil {
+		return err
+	}
+	for k6, v7 := range s.Weights {
+		if err := w.WriteI32(int32(k6)); err != nil {
+			return err
+		}
+		if err := w.WriteListBegin(thrift.TypeDouble, len(v7)); err != nil {
+			return err
+		}
+		for _, e8 := range v7 {
+			if err := w.WriteDouble(*e8); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteListEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteMapEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Visible == nil {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Visible"}
+	}
+	if err := w.WriteFieldBegin("Visible", thrift.TypeBool, 10); err != nil {
+		return err
+	}
+	if err := w.WriteBool(*s.Visible); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if s.Layer != nil {
+		if err := w.WriteFieldBegin("Layer", thrift.TypeI16, 11); err != nil {
+			return err
+		}
+		if err := w.WriteI16(*s.Layer); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if s.Flags != nil {
+		if err := w.WriteFieldBegin("Flags", thrift.TypeByte, 12); err != nil {
+			return err
+		}
+		if err := w.WriteByte(*s.Flags); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if s.Digests != nil {
+		if err := w.WriteFieldBegin("Digests", thrift.TypeSet, 13); err != nil {
+			return err
+		}
+		if err := w.WriteSetBegin(thrift.TypeString, len(s.Digests)); err != nil {
+			return err
+		}
+		for e9 := range s.Digests {
+			if err := w.WriteString(e9); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteSetEnd(); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	if err := w.WriteStructEnd(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Shape) DecodeThrift(r thrift.ProtocolReader) error {
+	if err := r.ReadStructBegin(); err != nil {
+		return err
+	}
+	issetPoints := false
+	issetName := false
+	issetIds := false
+	issetTags := false
+	issetWeights := false
+	issetVisible := false
+	for {
+		ftype, id, err := r.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if ftype == thrift.TypeStop {
+			break
+		}
+		switch id {
+		case 1:
+			if ftype != thrift.TypeI32 {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Color), Str: "type mismatch"}
+			}
+			v1, err := r.ReadI32()
+			if err != nil {
+				return err
+			}
+			t2 := Color(v1)
+			s.Color = &t2
+		case 2:
+			issetPoints = true
+			if ftype != thrift.TypeList {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Points), Str: "type mismatch"}
+			}
+			_, n4, err := r.ReadListBegin()
+			if err != nil {
+				return err
+			}
+			var v3 []*Point
+			for i := 0; i < n4; i++ {
+				v5 := &Point{}
+				if err := thrift.DecodeStruct(r, v5); err != nil {
+					return err
+				}
+				v3 = append(v3, v5)
+			}
+			if err := r.ReadListEnd(); err != nil {
+				return err
+			}
+			s.Points = v3
+		case 3:
+			issetName = true
+			if ftype != thrift.TypeString {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Name), Str: "type mismatch"}
+			}
+			v6, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			s.Name = &v6
+		case 4:
+			if ftype != thrift.TypeMap {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Counts), Str: "type mismatch"}
+			}
+			_, _, n8, err := r.ReadMapBegin()
+			if err != nil {
+				return err
+			}
+			v7 := make(map[string]Count, n8)
+			for i := 0; i < n8; i++ {
+				v9, err := r.ReadString()
+				if err != nil {
+					return err
+				}
+				v10, err := r.ReadI32()
+				if err != nil {
+					return err
+				}
+				v7[v9] = Count(v10)
+			}
+			if err := r.ReadMapEnd(); err != nil {
+				return err
+			}
+			s.Counts = v7
+		case 5:
+			issetIds = true
+			if ftype != thrift.TypeSet {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Ids), Str: "type mismatch"}
+			}
+			_, n12, err := r.ReadSetBegin()
+			if err != nil {
+				return err
+			}
+			v11 := make(map[int64]struct{}, n12)
+			for i := 0; i < n12; i++ {
+				v13, err := r.ReadI64()
+				if err != nil {
+					return err
+				}
+				v11[v13] = struct{}{}
+			}
+			if err := r.ReadSetEnd(); err != nil {
+				return err
+			}
+			s.Ids = v11
+		case 6:
+			if ftype != thrift.TypeString {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Data), Str: "type mismatch"}
+			}
+			v14, err := r.ReadBytes()
+			if err != nil {
+				return err
+			}
+			t15 := Blob(v14)
+			s.Data = &t15
+		case 7:
+			issetTags = true
+			if ftype != thrift.TypeList {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Tags), Str: "type mismatch"}
+			}
+			_, n17, err := r.ReadListBegin()
+			if err != nil {
+				return err
+			}
+			var v16 Names
+			for i := 0; i < n17; i++ {
+				v18, err := r.ReadString()
+				if err != nil {
+					return err
+				}
+				v16 = append(v16, &v18)
+			}
+			if err := r.ReadListEnd(); err != nil {
+				return err
+			}
+			s.Tags = &v16
+		case 8:
+			if ftype != thrift.TypeStruct {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Origin), Str: "type mismatch"}
+			}
+			v19 := &Point{}
+			if err := thrift.DecodeStruct(r, v19); err != nil {
+				return err
+			}
+			s.Origin = v19
+		case 9:
+			issetWeights = true
+			if ftype != thrift.TypeMap {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Weights), Str: "type mismatch"}
+			}
+			_, _, n21, err := r.ReadMapBegin()
+			if err != nil {
+				return err
+			}
+			v20 := make(map[Color][]*float64, n21)
+			for i := 0; i < n21; i++ {
+				v22, err := r.ReadI32()
+				if err != nil {
+					return err
+				}
+				_, n24, err := r.ReadListBegin()
+				if err != nil {
+					return err
+				}
+				var v23 []*float64
+				for i := 0; i < n24; i++ {
+					v25, err := r.ReadDouble()
+					if err != nil {
+						return err
+					}
+					v23 = append(v23, &v25)
+				}
+				if err := r.ReadListEnd(); err != nil {
+					return err
+				}
+				v20[Color(v22)] = v23
+			}
+			if err := r.ReadMapEnd(); err != nil {
+				return err
+			}
+			s.Weights = v20
+		case 10:
+			issetVisible = true
+			if ftype != thrift.TypeBool {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Visible), Str: "type mismatch"}
+			}
+			v26, err := r.ReadBool()
+			if err != nil {
+				return err
+			}
+			s.Visible = &v26
+		case 11:
+			if ftype != thrift.TypeI16 {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Layer), Str: "type mismatch"}
+			}
+			v27, err := r.ReadI16()
+			if err != nil {
+				return err
+			}
+			s.Layer = &v27
+		case 12:
+			if ftype != thrift.TypeByte {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Flags), Str: "type mismatch"}
+			}
+			v28, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			s.Flags = &v28
+		case 13:
+			if ftype != thrift.TypeSet {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Digests), Str: "type mismatch"}
+			}
+			_, n30, err := r.ReadSetBegin()
+			if err != nil {
+				return err
+			}
+			v29 := make(map[string]struct{}, n30)
+			for i := 0; i < n30; i++ {
+				v31, err := r.ReadString()
+				if err != nil {
+					return err
+				}
+				v29[v31] = struct{}{}
+			}
+			if err := r.ReadSetEnd(); err != nil {
+				return err
+			}
+			s.Digests = v29
+		default:
+			if err := thrift.SkipValue(r, ftype); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := r.ReadStructEnd(); err != nil {
+		return err
+	}
+	if !issetPoints {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Points"}
+	}
+	if !issetName {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Name"}
+	}
+	if !issetIds {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Ids"}
+	}
+	if !issetTags {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Tags"}
+	}
+	if !issetWeights {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Weights"}
+	}
+	if !issetVisible {
+		return &thrift.MissingRequiredField{StructName: "Shape", FieldName: "Visible"}
+	}
+	return nil
+}
+
+type ShapeError struct {
+	Message *string `thrift:"1,required" json:"message"`
+}
+
+func (s *ShapeError) EncodeThrift(w thrift.ProtocolWriter) error {
+	if err := w.WriteStructBegin("ShapeError"); err != nil {
+		return err
+	}
+	if s.Message == nil {
+		return &thrift.MissingRequiredField{StructName: "ShapeError", FieldName: "Message"}
+	}
+	if err := w.WriteFieldBegin("Message", thrift.TypeString, 1); err != nil {
+		return err
+	}
+	if err := w.WriteString(*s.Message); err != nil {
+		return err
+	}
+	if err := w.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	if err := w.WriteStructEnd(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *ShapeError) DecodeThrift(r thrift.ProtocolReader) error {
+	if err := r.ReadStructBegin(); err != nil {
+		return err
+	}
+	issetMessage := false
+	for {
+		ftype, id, err := r.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if ftype == thrift.TypeStop {
+			break
+		}
+		switch id {
+		case 1:
+			issetMessage = true
+			if ftype != thrift.TypeString {
+				return &thrift.UnsupportedValueError{Value: reflect.ValueOf(s.Message), Str: "type mismatch"}
+			}
+			v1, err := r.ReadString()
+			if err != nil {
+				return err
+			}
+			s.Message = &v1
+		default:
+			if err := thrift.SkipValue(r, ftype); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := r.ReadStructEnd(); err != nil {
+		return err
+	}
+	if !issetMessage {
+		return &thrift.MissingRequiredField{StructName: "ShapeError", FieldName: "Message"}
+	}
+	return nil
+}
+
+func (e *ShapeError) Error() string {
+	return fmt.Sprintf("ShapeError{Message: %+v}", e.Message)
+}
@@ -0,0 +1,35 @@
+namespace go gentest
+
+enum Color {
+	RED = 1,
+	GREEN = 2
+}
+
+typedef binary Blob
+typedef i32 Count
+typedef list<string> Names
+
+struct Point {
+	1: i32 x,
+	2: i32 y,
+}
+
+struct Shape {
+	3: string name,
+	1: optional Color color,
+	2: list<Point> points,
+	4: optional map<string, Count> counts,
+	5: set<i64> ids,
+	6: optional Blob data,
+	7: Names tags,
+	8: optional Point origin,
+	9: map<Color, list<double>> weights,
+	10: bool visible,
+	11: optional i16 layer,
+	12: optional byte flags,
+	13: optional set<binary> digests,
+}
+
+exception ShapeError {
+	1: string message,
+}
@@ -0,0 +1,146 @@
+package gentest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/samuel/go-thrift/thrift"
+)
+
+// shapeReflect and pointReflect have the same fields as Shape and Point
+// but not the generated methods so the thrift package encodes them using
+// reflection.
+type shapeReflect Shape
+type pointReflect Point
+
+// shapeExtra is a newer Shape with fields Shape doesn't know about
+type shapeExtra struct {
+	shapeReflect
+	Extra  *string       `thrift:"20"`
+	More   []int32       `thrift:"21"`
+	Nested *pointReflect `thrift:"22"`
+}
+
+var testProtocols = []struct {
+	name string
+	p    thrift.ProtocolBuilder
+}{
+	{"binary", thrift.BinaryProtocol},
+	{"compact", thrift.CompactProtocol},
+}
+
+func strPtr(v string) *string   { return &v }
+func i32Ptr(v int32) *int32     { return &v }
+func f64Ptr(v float64) *float64 { return &v }
+func boolPtr(v bool) *bool      { return &v }
+func colorPtr(v Color) *Color   { return &v }
+func i16Ptr(v int16) *int16     { return &v }
+func bytePtr(v byte) *byte      { return &v }
+func blobPtr(v Blob) *Blob      { return &v }
+func namesPtr(v Names) *Names   { return &v }
+
+// testShapes returns shapes whose maps and sets have at most one entry so
+// their encoding doesn't depend on iteration order.
+func testShapes() []*Shape {
+	return []*Shape{
+		{
+			Name:    strPtr("square"),
+			Color:   colorPtr(ColorGreen),
+			Points:  []*Point{{X: i32Ptr(1), Y: i32Ptr(-2)}, {X: i32Ptr(1 << 20), Y: i32Ptr(0)}},
+			Counts:  map[string]Count{"edges": 4},
+			Ids:     map[int64]struct{}{1 << 40: {}},
+			Data:    blobPtr(Blob{0, 1, 0xff}),
+			Tags:    namesPtr(Names{strPtr("a"), strPtr("")}),
+			Origin:  &Point{X: i32Ptr(3), Y: i32Ptr(4)},
+			Weights: map[Color][]*float64{ColorRed: {f64Ptr(1.5), f64Ptr(-2)}},
+			Visible: boolPtr(true),
+			Layer:   i16Ptr(-3),
+			Flags:   bytePtr(0x80),
+			Digests: map[string]struct{}{"digest": {}},
+		},
+		// Only the required fields are set
+		{
+			Name:    strPtr(""),
+			Points:  nil,
+			Ids:     map[int64]struct{}{},
+			Tags:    namesPtr(nil),
+			Weights: map[Color][]*float64{},
+			Visible: boolPtr(false),
+		},
+	}
+}
+
+func encode(t *testing.T, p thrift.ProtocolBuilder, v interface{}) []byte {
+	buf := &bytes.Buffer{}
+	if err := thrift.EncodeStruct(p.NewProtocolWriter(buf), v); err != nil {
+		t.Fatalf("Failed to encode %T: %+v", v, err)
+	}
+	return buf.Bytes()
+}
+
+func TestCodecMatchesReflection(t *testing.T) {
+	for _, tp := range testProtocols {
+		for i, s := range testShapes() {
+			generated := encode(t, tp.p, s)
+			reflected := encode(t, tp.p, (*shapeReflect)(s))
+			if !bytes.Equal(generated, reflected) {
+				t.Fatalf("%s: shape %d encoded as\n%x\ninstead of\n%x", tp.name, i, generated, reflected)
+			}
+
+			s2 := &Shape{}
+			if err := thrift.DecodeStruct(tp.p.NewProtocolReader(bytes.NewReader(generated)), s2); err != nil {
+				t.Fatalf("%s: failed to decode shape %d: %+v", tp.name, i, err)
+			}
+			s3 := &shapeReflect{}
+			if err := thrift.DecodeStruct(tp.p.NewProtocolReader(bytes.NewReader(generated)), s3); err != nil {
+				t.Fatalf("%s: failed to decode shape %d: %+v", tp.name, i, err)
+			}
+			if !reflect.DeepEqual((*shapeReflect)(s2), s3) {
+				t.Fatalf("%s: shape %d decoded as %+v instead of %+v", tp.name, i, s2, s3)
+			}
+		}
+
+		pt := &Point{X: i32Ptr(-1), Y: i32Ptr(1 << 30)}
+		if generated, reflected := encode(t, tp.p, pt), encode(t, tp.p, (*pointReflect)(pt)); !bytes.Equal(generated, reflected) {
+			t.Fatalf("%s: point encoded as %x instead of %x", tp.name, generated, reflected)
+		}
+
+		// Missing required fields fail the same way
+		if err := thrift.EncodeStruct(tp.p.NewProtocolWriter(&bytes.Buffer{}), &Point{X: i32Ptr(1)}); err == nil {
+			t.Fatalf("%s: expected an error encoding a point without Y", tp.name)
+		}
+		if err := thrift.EncodeStruct(tp.p.NewProtocolWriter(&bytes.Buffer{}), &pointReflect{X: i32Ptr(1)}); err == nil {
+			t.Fatalf("%s: expected an error encoding a point without Y", tp.name)
+		}
+	}
+}
+
+func TestCodecSkipsUnknownFields(t *testing.T) {
+	s := testShapes()[0]
+	newer := &shapeExtra{
+		shapeReflect: *(*shapeReflect)(s),
+		Extra:        strPtr("extra"),
+		More:         []int32{1, 2, 3},
+		Nested:       &pointReflect{X: i32Ptr(5), Y: i32Ptr(6)},
+	}
+	for _, tp := range testProtocols {
+		data := encode(t, tp.p, newer)
+
+		generated := &Shape{}
+		if err := thrift.DecodeStruct(tp.p.NewProtocolReader(bytes.NewReader(data)), generated); err != nil {
+			t.Fatalf("%s: failed to decode: %+v", tp.name, err)
+		}
+		reflected := &shapeReflect{}
+		if err := thrift.DecodeStruct(tp.p.NewProtocolReader(bytes.NewReader(data)), reflected); err != nil {
+			t.Fatalf("%s: failed to decode: %+v", tp.name, err)
+		}
+		if !reflect.DeepEqual((*shapeReflect)(generated), reflected) {
+			t.Fatalf("%s: decoded %+v instead of %+v", tp.name, generated, reflected)
+		}
+		expected := encode(t, tp.p, s)
+		if out := encode(t, tp.p, generated); !bytes.Equal(out, expected) {
+			t.Fatalf("%s: re-encoded as\n%x\ninstead of\n%x", tp.name, out, expected)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// This file is automatically generated. Do not modify.
+
+package gentest
+
+import (
+	"fmt"
+)
+
+var _ = fmt.Sprintf
+
+type NotFound struct {
+	Key *string `thrift:"1,required" json:"key"`
+}
+
+func (e *NotFound) Error() string {
+	return fmt.Sprintf("NotFound{Key: %+v}", e.Key)
+}
+
+type KeyValue interface {
+	Expire(key *string) error
+	Get(key *string) (*string, error)
+	Put(key *string, value *string) error
+	Touch(key *string) error
+}
+
+type KeyValueServer struct {
+	Implementation KeyValue
+}
+
+func (s *KeyValueServer) Expire(req *KeyValueExpireRequest, _ *struct{}) error {
+	err := s.Implementation.Expire(req.Key)
+	return err
+}
+
+func (s *KeyValueServer) Get(req *KeyValueGetRequest, res *KeyValueGetResponse) error {
+	val, err := s.Implementation.Get(req.Key)
+	switch e := err.(type) {
+	case *NotFound:
+		res.NotFound = e
+		err = nil
+	}
+	res.Value = val
+	return err
+}
+
+func (s *KeyValueServer) Put(req *KeyValuePutRequest, res *KeyValuePutResponse) error {
+	err := s.Implementation.Put(req.Key, req.Value)
+	return err
+}
+
+func (s *KeyValueServer) Touch(req *KeyValueTouchRequest, res *KeyValueTouchResponse) error {
+	err := s.Implementation.Touch(req.Key)
+	return err
+}
+
+type KeyValueExpireRequest struct {
+	Key *string `thrift:"1,required" json:"key"`
+}
+
+func (r *KeyValueExpireRequest) Oneway() bool {
+	return true
+}
+
+type KeyValueGetRequest struct {
+	Key *string `thrift:"1,required" json:"key"`
+}
+
+func (r *KeyValueGetRequest) Idempotent() bool {
+	return true
+}
+
+type KeyValueGetResponse struct {
+	Value    *string   `thrift:"0" json:"value,omitempty"`
+	NotFound *NotFound `thrift:"1" json:"notFound,omitempty"`
+}
+
+type KeyValuePutRequest struct {
+	Key   *string `thrift:"1,required" json:"key"`
+	Value *string `thrift:"2,required" json:"value"`
+}
+
+type KeyValuePutResponse struct {
+}
+
+type KeyValueTouchRequest struct {
+	Key *string `thrift:"1,required" json:"key"`
+}
+
+func (r *KeyValueTouchRequest) Idempotent() bool {
+	return true
+}
+
+type KeyValueTouchResponse struct {
+}
+
+type KeyValueClient struct {
+	Client RPCClient
+}
+
+func (s *KeyValueClient) Expire(key *string) (err error) {
+	req := &KeyValueExpireRequest{
+		Key: key,
+	}
+	var res interface{} = nil
+	err = s.Client.Call("expire", req, res)
+	return
+}
+
+func (s *KeyValueClient) Get(key *string) (ret *string, err error) {
+	req := &KeyValueGetRequest{
+		Key: key,
+	}
+	res := &KeyValueGetResponse{}
+	err = s.Client.Call("get", req, res)
+	if err == nil {
+		switch {
+		case res.NotFound != nil:
+			err = res.NotFound
+		}
+	}
+	if err == nil {
+		ret = res.Value
+	}
+	return
+}
+
+func (s *KeyValueClient) Put(key *string, value *string) (err error) {
+	req := &KeyValuePutRequest{
+		Key:   key,
+		Value: value,
+	}
+	res := &KeyValuePutResponse{}
+	err = s.Client.Call("put", req, res)
+	return
+}
+
+func (s *KeyValueClient) Touch(key *string) (err error) {
+	req := &KeyValueTouchRequest{
+		Key: key,
+	}
+	res := &KeyValueTouchResponse{}
+	err = s.Client.Call("touch", req, res)
+	return
+}
@@ -0,0 +1,12 @@
+namespace go gentest
+
+exception NotFound {
+	1: string key,
+}
+
+service KeyValue {
+	string get(1: string key) throws (1: NotFound notFound) (idempotent = "true"),
+	void put(1: string key, 2: string value),
+	void touch(1: string key) (idempotent = "true"),
+	oneway void expire(1: string key),
+}
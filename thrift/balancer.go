@@ -0,0 +1,295 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoHosts is the error returned by a Balancer that has no addresses.
+var ErrNoHosts = errors.New("thrift.client: no hosts")
+
+// BalanceStrategy chooses the host a Balancer sends a call to.
+type BalanceStrategy int
+
+const (
+	// BalanceRoundRobin sends calls to each host in turn.
+	BalanceRoundRobin BalanceStrategy = iota
+	// BalanceLeastPending sends calls to the host with the fewest calls in
+	// progress, taking turns between hosts that are tied.
+	BalanceLeastPending
+)
+
+const (
+	defaultBalancerCooldown        = 10 * time.Second
+	defaultBalancerRefreshInterval = 30 * time.Second
+	defaultBalancerRetryInterval   = time.Second
+)
+
+// Balancer is an RPCClient that spreads calls over several addresses
+// serving the same service. Each address has its own Pool of connections.
+// A host whose call fails with a connection or dial error is marked down
+// and skipped for Cooldown. If every host is down calls are sent to all of
+// them as if none were.
+//
+// The exported fields must be set before the first call.
+type Balancer struct {
+	// Strategy defaults to BalanceRoundRobin.
+	Strategy BalanceStrategy
+	// Cooldown is how long a failing host is skipped. Defaults to 10s.
+	Cooldown time.Duration
+	// PoolSize is the number of connections per address. Defaults to 1.
+	PoolSize int
+	// RefreshInterval is how often the addresses are resolved again.
+	// Defaults to 30s.
+	RefreshInterval time.Duration
+	// RetryInterval is how soon a failed resolve is retried while no
+	// addresses are known. Defaults to 1s or RefreshInterval if shorter.
+	RetryInterval time.Duration
+
+	resolve func() ([]string, error)
+	dial    func(address string) (*NetRPCClient, error)
+
+	mu         sync.Mutex // protects the following
+	hosts      []*balancerHost
+	next       int
+	resolved   time.Time
+	resolving  chan struct{} // closed once the resolve in progress is done
+	resolveErr error         // from the last resolve
+	closed     bool
+}
+
+type balancerHost struct {
+	addr string
+	pool *Pool
+
+	// protected by Balancer.mu
+	pending   int
+	downUntil time.Time
+	removed   bool
+}
+
+// NewBalancer returns a Balancer over the addresses returned by resolve,
+// such as StaticAddresses, that opens connections by calling dial with an
+// address. For example:
+//
+//	b := thrift.NewBalancer(thrift.StaticAddresses(addrs...), func(addr string) (*thrift.NetRPCClient, error) {
+//		return thrift.DialNetRPCClient("tcp", addr, true, thrift.BinaryProtocol, false)
+//	})
+//	client := &XxxClient{Client: b}
+func NewBalancer(resolve func() ([]string, error), dial func(address string) (*NetRPCClient, error)) *Balancer {
+	return &Balancer{
+		resolve: resolve,
+		dial:    dial,
+	}
+}
+
+// StaticAddresses returns a resolver for a Balancer that always returns
+// addrs.
+func StaticAddresses(addrs ...string) func() ([]string, error) {
+	return func() ([]string, error) {
+		return addrs, nil
+	}
+}
+
+// Call invokes the named method on one of the hosts.
+func (b *Balancer) Call(method string, request interface{}, response interface{}) error {
+	h, err := b.pick()
+	if err != nil {
+		return err
+	}
+
+	pc, client, err := h.pool.get()
+	if err == nil {
+		err = client.Call(method, request, response)
+		pc.release(client, err)
+		if !isConnError(err) {
+			b.release(h, false)
+			return err
+		}
+	}
+	b.release(h, true)
+	return err
+}
+
+// Close closes the connections to all hosts. Later calls return
+// ErrPoolClosed.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	hosts := b.hosts
+	b.hosts = nil
+	b.mu.Unlock()
+
+	var err error
+	for _, h := range hosts {
+		if e := h.pool.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// pick chooses a host for a call and counts the call as pending on it.
+func (b *Balancer) pick() (*balancerHost, error) {
+	b.refresh()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrPoolClosed
+	}
+	if len(b.hosts) == 0 {
+		if b.resolveErr != nil {
+			return nil, b.resolveErr
+		}
+		return nil, ErrNoHosts
+	}
+
+	now := time.Now()
+	up := 0
+	for _, h := range b.hosts {
+		if !now.Before(h.downUntil) {
+			up++
+		}
+	}
+
+	var best *balancerHost
+	n := len(b.hosts)
+	for i := 0; i < n; i++ {
+		h := b.hosts[(b.next+i)%n]
+		if up > 0 && now.Before(h.downUntil) {
+			continue
+		}
+		if best == nil || (b.Strategy == BalanceLeastPending && h.pending < best.pending) {
+			best = h
+			if b.Strategy != BalanceLeastPending {
+				break
+			}
+		}
+	}
+	b.next = (b.next + 1) % n
+	best.pending++
+	return best, nil
+}
+
+// refresh resolves the addresses if they're due to be. The resolver is
+// called without the lock held and by one caller at a time. The others
+// carry on with the current hosts or wait for it if there are none yet.
+// Hosts that are no longer listed are closed once their pending calls
+// finish. If the last resolve failed and there are no hosts it's retried
+// after RetryInterval rather than RefreshInterval.
+func (b *Balancer) refresh() {
+	interval := b.RefreshInterval
+	if interval <= 0 {
+		interval = defaultBalancerRefreshInterval
+	}
+	retry := b.RetryInterval
+	if retry <= 0 {
+		retry = defaultBalancerRetryInterval
+	}
+	if retry > interval {
+		retry = interval
+	}
+	b.mu.Lock()
+	for {
+		due := interval
+		if len(b.hosts) == 0 && b.resolveErr != nil {
+			due = retry
+		}
+		if b.closed || (!b.resolved.IsZero() && time.Since(b.resolved) < due) {
+			b.mu.Unlock()
+			return
+		}
+		if b.resolving == nil {
+			break
+		}
+		if len(b.hosts) > 0 {
+			b.mu.Unlock()
+			return
+		}
+		done := b.resolving
+		b.mu.Unlock()
+		<-done
+		b.mu.Lock()
+	}
+	done := make(chan struct{})
+	b.resolving = done
+	b.mu.Unlock()
+
+	addrs, err := b.resolve()
+
+	b.mu.Lock()
+	b.resolving = nil
+	close(done)
+	b.resolved = time.Now()
+	// Keep using the hosts from the last resolve if it fails
+	b.resolveErr = err
+	var closing []*balancerHost
+	if err == nil && !b.closed {
+		old := make(map[string]*balancerHost, len(b.hosts))
+		for _, h := range b.hosts {
+			old[h.addr] = h
+		}
+		hosts := make([]*balancerHost, 0, len(addrs))
+		for _, addr := range addrs {
+			h := old[addr]
+			if h == nil {
+				h = b.newHost(addr)
+			}
+			delete(old, addr)
+			hosts = append(hosts, h)
+		}
+		for _, h := range old {
+			h.removed = true
+			if h.pending == 0 {
+				closing = append(closing, h)
+			}
+		}
+		b.hosts = hosts
+		if b.next >= len(hosts) {
+			b.next = 0
+		}
+	}
+	b.mu.Unlock()
+
+	for _, h := range closing {
+		h.pool.Close()
+	}
+}
+
+func (b *Balancer) newHost(addr string) *balancerHost {
+	size := b.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+	return &balancerHost{
+		addr: addr,
+		pool: NewPool(size, func() (*NetRPCClient, error) { return b.dial(addr) }),
+	}
+}
+
+// release finishes a call on h marking it down if it failed.
+func (b *Balancer) release(h *balancerHost, failed bool) {
+	b.mu.Lock()
+	h.pending--
+	if failed {
+		cooldown := b.Cooldown
+		if cooldown <= 0 {
+			cooldown = defaultBalancerCooldown
+		}
+		h.downUntil = time.Now().Add(cooldown)
+	}
+	closing := h.removed && h.pending == 0
+	b.mu.Unlock()
+
+	// Closing waits for connections being dialed so isn't done holding
+	// the lock
+	if closing {
+		h.pool.Close()
+	}
+}
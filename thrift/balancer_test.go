@@ -0,0 +1,243 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHostService replies with the ID of the host. If gate is set calls
+// signal arrived and then wait for gate to be closed.
+type TestHostService struct {
+	id      int32
+	arrived chan struct{}
+	gate    chan struct{}
+}
+
+func (s *TestHostService) Which(req *TestRequest, res *TestResponse) error {
+	if s.gate != nil {
+		s.arrived <- struct{}{}
+		<-s.gate
+	}
+	res.Value = s.id
+	return nil
+}
+
+// startTestHosts starts a Server for each service returning the servers and
+// their addresses.
+func startTestHosts(services ...*TestHostService) ([]*Server, []string) {
+	var servers []*Server
+	var addrs []string
+	for _, svc := range services {
+		s := NewServer()
+		s.Register(svc)
+		addr, _ := startTestServer(s)
+		servers = append(servers, s)
+		addrs = append(addrs, addr)
+	}
+	return servers, addrs
+}
+
+func dialTestHost(addr string) (*NetRPCClient, error) {
+	return DialNetRPCClient("tcp", addr, true, BinaryProtocol, false)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	servers, addrs := startTestHosts(&TestHostService{id: 0}, &TestHostService{id: 1}, &TestHostService{id: 2})
+	for _, s := range servers {
+		defer s.Shutdown(context.Background())
+	}
+
+	b := NewBalancer(StaticAddresses(addrs...), dialTestHost)
+	b.Cooldown = time.Hour
+	defer b.Close()
+
+	counts := make([]int, len(addrs))
+	for i := 0; i < 30; i++ {
+		res := &TestResponse{}
+		if err := b.Call("Which", &TestRequest{}, res); err != nil {
+			t.Fatalf("Balancer.Call returned error: %+v", err)
+		}
+		counts[res.Value]++
+	}
+	for id, n := range counts {
+		if n != 10 {
+			t.Fatalf("Expected 10 calls to host %d instead of %d", id, n)
+		}
+	}
+
+	// A host that fails is skipped for the cooldown
+	servers[1].Shutdown(context.Background())
+	counts = make([]int, len(addrs))
+	failed := 0
+	for i := 0; i < 30; i++ {
+		res := &TestResponse{}
+		if err := b.Call("Which", &TestRequest{}, res); err != nil {
+			failed++
+			continue
+		}
+		counts[res.Value]++
+	}
+	if failed != 1 || counts[1] != 0 || counts[0]+counts[2] != 29 {
+		t.Fatalf("Expected 1 failure and 29 calls to hosts 0 and 2 instead of %d and %v", failed, counts)
+	}
+}
+
+func TestBalancerLeastPending(t *testing.T) {
+	slow := &TestHostService{id: 0, arrived: make(chan struct{}), gate: make(chan struct{})}
+	servers, addrs := startTestHosts(slow, &TestHostService{id: 1})
+	for _, s := range servers {
+		defer s.Shutdown(context.Background())
+	}
+
+	b := NewBalancer(StaticAddresses(addrs...), dialTestHost)
+	b.Strategy = BalanceLeastPending
+	defer b.Close()
+
+	// Calls stuck on the slow host keep later calls away from it
+	counts := make([]int, len(addrs))
+	var stuck []chan *TestResponse
+	for i := 0; i < 10; i++ {
+		done := make(chan *TestResponse, 1)
+		go func() {
+			res := &TestResponse{}
+			if err := b.Call("Which", &TestRequest{}, res); err != nil {
+				t.Errorf("Balancer.Call returned error: %+v", err)
+			}
+			done <- res
+		}()
+		select {
+		case <-slow.arrived:
+			counts[0]++
+			stuck = append(stuck, done)
+		case res := <-done:
+			counts[res.Value]++
+		}
+	}
+	close(slow.gate)
+	for _, done := range stuck {
+		<-done
+	}
+	if counts[0] != 1 || counts[1] != 9 {
+		t.Fatalf("Expected 1 call to the slow host and 9 to the other instead of %v", counts)
+	}
+}
+
+func TestBalancerResolve(t *testing.T) {
+	servers, addrs := startTestHosts(&TestHostService{id: 0}, &TestHostService{id: 1})
+	for _, s := range servers {
+		defer s.Shutdown(context.Background())
+	}
+
+	resolved := addrs[:1]
+	b := NewBalancer(func() ([]string, error) { return resolved, nil }, dialTestHost)
+	b.RefreshInterval = time.Millisecond
+	defer b.Close()
+
+	res := &TestResponse{}
+	if err := b.Call("Which", &TestRequest{}, res); err != nil {
+		t.Fatalf("Balancer.Call returned error: %+v", err)
+	} else if res.Value != 0 {
+		t.Fatalf("Expected host 0 instead of %d", res.Value)
+	}
+
+	resolved = addrs[1:]
+	time.Sleep(2 * b.RefreshInterval)
+	if err := b.Call("Which", &TestRequest{}, res); err != nil {
+		t.Fatalf("Balancer.Call returned error: %+v", err)
+	} else if res.Value != 1 {
+		t.Fatalf("Expected host 1 instead of %d", res.Value)
+	}
+
+	resolved = nil
+	time.Sleep(2 * b.RefreshInterval)
+	if err := b.Call("Which", &TestRequest{}, res); err != ErrNoHosts {
+		t.Fatalf("Expected ErrNoHosts instead of %+v", err)
+	}
+}
+
+func TestBalancerResolveRetry(t *testing.T) {
+	servers, addrs := startTestHosts(&TestHostService{id: 0})
+	defer servers[0].Shutdown(context.Background())
+
+	resolveErr := errors.New("resolve failed")
+	var calls int32
+	b := NewBalancer(func() ([]string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, resolveErr
+		}
+		return addrs, nil
+	}, dialTestHost)
+	b.RetryInterval = 50 * time.Millisecond
+	defer b.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := b.Call("Which", &TestRequest{}, &TestResponse{}); err != resolveErr {
+			t.Fatalf("Expected %+v instead of %+v", resolveErr, err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected 1 resolve before RetryInterval instead of %d", n)
+	}
+
+	// Retried well before the default RefreshInterval
+	time.Sleep(2 * b.RetryInterval)
+	if err := b.Call("Which", &TestRequest{}, &TestResponse{}); err != nil {
+		t.Fatalf("Balancer.Call returned error: %+v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("Expected 2 resolves instead of %d", n)
+	}
+}
+
+func TestBalancerSlowResolve(t *testing.T) {
+	servers, addrs := startTestHosts(&TestHostService{id: 0})
+	defer servers[0].Shutdown(context.Background())
+
+	var calls int32
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	b := NewBalancer(func() ([]string, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			entered <- struct{}{}
+			<-unblock
+		}
+		return addrs, nil
+	}, dialTestHost)
+	b.RefreshInterval = time.Millisecond
+	defer b.Close()
+
+	if err := b.Call("Which", &TestRequest{}, &TestResponse{}); err != nil {
+		t.Fatalf("Balancer.Call returned error: %+v", err)
+	}
+	time.Sleep(2 * b.RefreshInterval)
+	resolving := make(chan error, 1)
+	go func() {
+		resolving <- b.Call("Which", &TestRequest{}, &TestResponse{})
+	}()
+	<-entered
+
+	// Calls carry on with the current hosts while another resolves
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Call("Which", &TestRequest{}, &TestResponse{})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Balancer.Call returned error: %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Balancer.Call blocked on the resolver")
+	}
+	close(unblock)
+	if err := <-resolving; err != nil {
+		t.Fatalf("Balancer.Call returned error: %+v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen matches the error returned by a CircuitBreaker that
+// isn't letting calls through when compared using errors.Is.
+var ErrCircuitOpen = errors.New("thrift.client: circuit breaker open")
+
+// CircuitOpenError is the error returned by a CircuitBreaker that isn't
+// letting calls through. It matches ErrCircuitOpen.
+type CircuitOpenError struct {
+	State CircuitState
+	// RetryAfter is when the breaker will let a probe through. It's zero
+	// while a half-open breaker's probe is in flight.
+	RetryAfter time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return ErrCircuitOpen.Error() + " (" + e.State.String() + ")"
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all calls through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails calls with a *CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a single call through to probe whether the
+	// service has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	defaultBreakerConsecutiveFailures = 5
+	defaultBreakerMinRequests         = 20
+	defaultBreakerWindow              = 10 * time.Second
+	defaultBreakerOpenTimeout         = 5 * time.Second
+)
+
+// CircuitBreaker is an RPCClient that stops making calls once they start
+// failing so callers fail fast rather than waiting on an unhealthy
+// service. It opens after ConsecutiveFailures failed calls in a row or,
+// if FailureRate is set, once that fraction of the calls in a Window has
+// failed. While open calls return a *CircuitOpenError. After OpenTimeout the
+// breaker is half-open and the next call is let through: if it succeeds
+// the breaker closes and otherwise it opens again.
+//
+// The exported fields must be set before the first call.
+type CircuitBreaker struct {
+	Client RPCClient
+	// ConsecutiveFailures opens the breaker after this many failures in a
+	// row. Defaults to 5.
+	ConsecutiveFailures int
+	// FailureRate opens the breaker when the fraction of calls that fail
+	// in a Window reaches it. The rate is only checked once MinRequests
+	// calls have been made in the window. Zero disables it.
+	FailureRate float64
+	MinRequests int           // defaults to 20
+	Window      time.Duration // defaults to 10s
+	// OpenTimeout is how long the breaker stays open before probing.
+	// Defaults to 5s.
+	OpenTimeout time.Duration
+	// IsFailure decides which errors count as failures. By default they
+	// all do.
+	IsFailure func(error) bool
+	// OnStateChange, if set, is called when the state changes. It's
+	// called with the breaker's lock held so mustn't call its methods.
+	OnStateChange func(from, to CircuitState)
+
+	mu          sync.Mutex // protects the following
+	state       CircuitState
+	consecutive int
+	calls       int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreaker returns a CircuitBreaker making calls with client.
+func NewCircuitBreaker(client RPCClient) *CircuitBreaker {
+	return &CircuitBreaker{Client: client}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openTimeout() {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// Call invokes the named method unless the breaker is open.
+func (b *CircuitBreaker) Call(method string, request interface{}, response interface{}) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	// A call that panics counts as a failure so a probe can't leave the
+	// breaker half-open.
+	failed := true
+	defer func() {
+		b.record(probe, failed)
+	}()
+	err = b.Client.Call(method, request, response)
+	failed = err != nil
+	if failed && b.IsFailure != nil {
+		failed = b.IsFailure(err)
+	}
+	return err
+}
+
+// allow returns whether a call may be made and if so whether it's the
+// probe of a half-open breaker.
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if retryAfter := b.openedAt.Add(b.openTimeout()); time.Now().Before(retryAfter) {
+			return false, &CircuitOpenError{State: CircuitOpen, RetryAfter: retryAfter}
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probing {
+			return false, &CircuitOpenError{State: CircuitHalfOpen}
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *CircuitBreaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if probe {
+		b.probing = false
+		if failed {
+			b.open(now)
+		} else {
+			b.reset(now)
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	if b.state != CircuitClosed {
+		// Started before the breaker opened
+		return
+	}
+
+	window := b.Window
+	if window <= 0 {
+		window = defaultBreakerWindow
+	}
+	if now.Sub(b.windowStart) >= window {
+		b.windowStart = now
+		b.calls = 0
+		b.failures = 0
+	}
+	b.calls++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+
+	maxConsecutive := b.ConsecutiveFailures
+	if maxConsecutive <= 0 {
+		maxConsecutive = defaultBreakerConsecutiveFailures
+	}
+	minRequests := b.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultBreakerMinRequests
+	}
+	if b.consecutive >= maxConsecutive ||
+		(b.FailureRate > 0 && b.calls >= minRequests && float64(b.failures)/float64(b.calls) >= b.FailureRate) {
+		b.open(now)
+	}
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.openedAt = now
+	b.reset(now)
+	b.setState(CircuitOpen)
+}
+
+func (b *CircuitBreaker) reset(now time.Time) {
+	b.consecutive = 0
+	b.calls = 0
+	b.failures = 0
+	b.windowStart = now
+}
+
+func (b *CircuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, state)
+	}
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout <= 0 {
+		return defaultBreakerOpenTimeout
+	}
+	return b.OpenTimeout
+}
@@ -0,0 +1,176 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// errClient fails calls with err and counts them
+type errClient struct {
+	err   error
+	calls int
+}
+
+func (c *errClient) Call(method string, request interface{}, response interface{}) error {
+	c.calls++
+	return c.err
+}
+
+func TestCircuitBreakerConsecutive(t *testing.T) {
+	ec := &errClient{err: io.ErrUnexpectedEOF}
+	b := NewCircuitBreaker(ec)
+	b.ConsecutiveFailures = 3
+	b.OpenTimeout = 20 * time.Millisecond
+	var changes []string
+	b.OnStateChange = func(from, to CircuitState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	for i := 0; i < 5; i++ {
+		err := b.Call("Success", &TestRequest{}, &TestResponse{})
+		if i < 3 && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Call %d: expected %+v instead of %+v", i, io.ErrUnexpectedEOF, err)
+		} else if i >= 3 && !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Call %d: expected ErrCircuitOpen instead of %+v", i, err)
+		}
+	}
+	if ec.calls != 3 || b.State() != CircuitOpen {
+		t.Fatalf("Expected 3 calls and an open breaker instead of %d and %s", ec.calls, b.State())
+	}
+
+	// A failed probe opens the breaker again
+	time.Sleep(b.OpenTimeout)
+	if s := b.State(); s != CircuitHalfOpen {
+		t.Fatalf("Expected a half-open breaker instead of %s", s)
+	}
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected the probe to fail with %+v instead of %+v", io.ErrUnexpectedEOF, err)
+	}
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen instead of %+v", err)
+	}
+
+	// A successful probe closes it
+	ec.err = nil
+	time.Sleep(b.OpenTimeout)
+	for i := 0; i < 3; i++ {
+		if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != nil {
+			t.Fatalf("Call returned error: %+v", err)
+		}
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("Expected a closed breaker instead of %s", s)
+	}
+
+	expected := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected state changes %v instead of %v", expected, changes)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	ec := &errClient{}
+	b := NewCircuitBreaker(ec)
+	b.FailureRate = 0.5
+	b.MinRequests = 10
+
+	// Alternating failures never reach the consecutive limit
+	for i := 0; i < 9; i++ {
+		if i%2 == 1 {
+			ec.err = io.EOF
+		} else {
+			ec.err = nil
+		}
+		b.Call("Success", &TestRequest{}, &TestResponse{})
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("Expected a closed breaker before MinRequests instead of %s", s)
+	}
+	ec.err = io.EOF
+	b.Call("Success", &TestRequest{}, &TestResponse{})
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("Expected an open breaker at a 50%% failure rate instead of %s", s)
+	}
+}
+
+func TestCircuitBreakerIsFailure(t *testing.T) {
+	ec := &errClient{err: &ApplicationException{"unknown", ExceptionUnknownMethod}}
+	b := NewCircuitBreaker(ec)
+	b.ConsecutiveFailures = 1
+	b.IsFailure = func(err error) bool {
+		var ex *ApplicationException
+		return !errors.As(err, &ex)
+	}
+	for i := 0; i < 3; i++ {
+		b.Call("Missing", &TestRequest{}, &TestResponse{})
+	}
+	if s := b.State(); s != CircuitClosed || ec.calls != 3 {
+		t.Fatalf("Expected a closed breaker and 3 calls instead of %s and %d", s, ec.calls)
+	}
+}
+
+func TestCircuitOpenError(t *testing.T) {
+	b := NewCircuitBreaker(&errClient{err: io.EOF})
+	b.ConsecutiveFailures = 1
+	b.OpenTimeout = time.Minute
+	before := time.Now()
+	b.Call("Success", &TestRequest{}, &TestResponse{})
+
+	err := b.Call("Success", &TestRequest{}, &TestResponse{})
+	var oe *CircuitOpenError
+	if !errors.As(err, &oe) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected a CircuitOpenError matching ErrCircuitOpen instead of %+v", err)
+	}
+	if oe.State != CircuitOpen || oe.RetryAfter.Before(before.Add(b.OpenTimeout)) || oe.RetryAfter.After(time.Now().Add(b.OpenTimeout)) {
+		t.Fatalf("Expected an open state and a retry after %s instead of %+v", b.OpenTimeout, oe)
+	}
+}
+
+// panicClient panics on the first call and then succeeds
+type panicClient struct {
+	calls int
+}
+
+func (c *panicClient) Call(method string, request interface{}, response interface{}) error {
+	c.calls++
+	if c.calls == 1 {
+		panic("probe")
+	}
+	return nil
+}
+
+func TestCircuitBreakerProbePanic(t *testing.T) {
+	b := NewCircuitBreaker(&panicClient{})
+	b.OpenTimeout = 10 * time.Millisecond
+	b.state = CircuitOpen
+	b.openedAt = time.Now().Add(-b.OpenTimeout)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "probe" {
+				t.Fatalf("Expected the probe to panic instead of %+v", r)
+			}
+		}()
+		b.Call("Success", &TestRequest{}, &TestResponse{})
+	}()
+
+	// The panic counts as a failed probe so the breaker probes again once
+	// the timeout passes.
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("Expected an open breaker instead of %s", s)
+	}
+	time.Sleep(b.OpenTimeout)
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != nil {
+		t.Fatalf("Expected the second probe to succeed instead of %+v", err)
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("Expected a closed breaker instead of %s", s)
+	}
+}
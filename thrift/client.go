@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"net/rpc"
+	"sync"
 )
 
 // Implements rpc.ClientCodec
@@ -17,6 +18,67 @@ type clientCodec struct {
 	onewayRequests chan pendingRequest
 	twowayRequests chan pendingRequest
 	enableOneway   bool
+	interceptors   interceptorChain
+
+	mu          sync.Mutex
+	calls       map[uint64]*clientCall // sequence ID -> call awaiting a response
+	outstanding int                    // number of requests awaiting a response
+
+	// Only used while reading a response
+	response  *clientCall
+	exception *ApplicationException
+}
+
+// clientCall is tracked for calls made through a NetRPCClient or when
+// there are interceptors.
+type clientCall struct {
+	method  string
+	seq     int32
+	request interface{}
+	net     *netRPCCall
+	headers *Headers // nil if the transport doesn't carry headers
+}
+
+// RPCClient is the interface generated clients use to make calls. It's
+// satisfied by *rpc.Client.
+type RPCClient interface {
+	Call(method string, request interface{}, response interface{}) error
+}
+
+// NetRPCClient is an rpc.Client using Thrift RPC. Call returns an
+// ApplicationException sent by the server as an *ApplicationException
+// rather than the rpc.ServerError that net/rpc would return so that its
+// Type can be inspected. Calls made through the embedded rpc.Client
+// (including Go) still return an rpc.ServerError.
+type NetRPCClient struct {
+	*rpc.Client
+}
+
+// netRPCCall is passed to net/rpc in place of the request so the codec
+// can hand back an exception and headers for it.
+type netRPCCall struct {
+	request   interface{}
+	headers   *Headers
+	exception *ApplicationException
+}
+
+// Call invokes the named method, waits for it to complete, and returns
+// its error status.
+func (c *NetRPCClient) Call(method string, request interface{}, response interface{}) error {
+	return c.CallWithHeaders(method, request, response, nil)
+}
+
+// CallWithHeaders is like Call but sends headers.Write with the request
+// and sets headers.Read to the response's headers. Headers are ignored if
+// the transport doesn't carry them.
+func (c *NetRPCClient) CallWithHeaders(method string, request interface{}, response interface{}, headers *Headers) error {
+	call := &netRPCCall{request: request, headers: headers}
+	err := c.Client.Call(method, call, response)
+	if call.exception != nil {
+		// Set by the codec before net/rpc completes the call
+		return call.exception
+	}
+	return err
 }
 
 type pendingRequest struct {
@@ -40,7 +102,31 @@ var (
 const maxPendingRequests = 1000
 
 // Dial connects to a Thrift RPC server at the specified network address using the specified protocol.
-func Dial(network, address string, framed bool, protocol ProtocolBuilder, supportOnewayRequests bool) (*rpc.Client, error) {
+// The interceptors are called around each call.
+//
+// Deprecated: net/rpc returns exceptions from the server as an
+// rpc.ServerError string so their Type is lost. Use DialNetRPCClient,
+// which returns them as *ApplicationException, or DialContext.
+func Dial(network, address string, framed bool, protocol ProtocolBuilder, supportOnewayRequests bool, interceptors ...Interceptor) (*rpc.Client, error) {
+	c, err := DialNetRPCClient(network, address, framed, protocol, supportOnewayRequests, interceptors...)
+	if err != nil {
+		return nil, err
+	}
+	return c.Client, nil
+}
+
+// NewClient returns a new rpc.Client to handle requests to the set of
+// services at the other end of the connection.
+//
+// Deprecated: net/rpc returns exceptions from the server as an
+// rpc.ServerError string so their Type is lost. Use NewNetRPCClient,
+// which returns them as *ApplicationException, or NewContextClient.
+func NewClient(conn Transport, supportOnewayRequests bool, interceptors ...Interceptor) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn, supportOnewayRequests, interceptors...))
+}
+
+// DialNetRPCClient is like Dial but returns a NetRPCClient.
+func DialNetRPCClient(network, address string, framed bool, protocol ProtocolBuilder, supportOnewayRequests bool, interceptors ...Interceptor) (*NetRPCClient, error) {
 	conn, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
@@ -49,27 +135,21 @@ func Dial(network, address string, framed bool, protocol ProtocolBuilder, suppor
 	if framed {
 		c = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
 	}
-	codec := &clientCodec{
-		conn: NewTransport(c, protocol),
-	}
-	if supportOnewayRequests {
-		codec.enableOneway = true
-		codec.onewayRequests = make(chan pendingRequest, maxPendingRequests)
-		codec.twowayRequests = make(chan pendingRequest, maxPendingRequests)
-	}
-	return rpc.NewClientWithCodec(codec), nil
+	return NewNetRPCClient(NewTransport(c, protocol), supportOnewayRequests, interceptors...), nil
 }
 
-// NewClient returns a new rpc.Client to handle requests to the set of
-// services at the other end of the connection.
-func NewClient(conn Transport, supportOnewayRequests bool) *rpc.Client {
-	return rpc.NewClientWithCodec(NewClientCodec(conn, supportOnewayRequests))
+// NewNetRPCClient is like NewClient but returns a NetRPCClient.
+func NewNetRPCClient(conn Transport, supportOnewayRequests bool, interceptors ...Interceptor) *NetRPCClient {
+	return &NetRPCClient{rpc.NewClientWithCodec(NewClientCodec(conn, supportOnewayRequests, interceptors...))}
 }
 
 // NewClientCodec returns a new rpc.ClientCodec using Thrift RPC on conn using the specified protocol.
-func NewClientCodec(conn Transport, supportOnewayRequests bool) rpc.ClientCodec {
+// The interceptors are called around each call.
+func NewClientCodec(conn Transport, supportOnewayRequests bool, interceptors ...Interceptor) rpc.ClientCodec {
 	c := &clientCodec{
-		conn: conn,
+		conn:         conn,
+		interceptors: interceptors,
+		calls:        make(map[uint64]*clientCall),
 	}
 	if supportOnewayRequests {
 		c.enableOneway = true
@@ -80,22 +160,60 @@ func NewClientCodec(conn Transport, supportOnewayRequests bool) rpc.ClientCodec
 }
 
 func (c *clientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{}) error {
-	if err := c.conn.WriteMessageBegin(request.ServiceMethod, MessageTypeCall, int32(request.Seq)); err != nil {
-		return err
+	var call *clientCall
+	if nc, ok := thriftStruct.(*netRPCCall); ok {
+		thriftStruct = nc.request
+		call = &clientCall{net: nc, headers: nc.headers}
+	} else if len(c.interceptors) > 0 {
+		call = &clientCall{}
 	}
-	if err := EncodeStruct(c.conn, thriftStruct); err != nil {
-		return err
+	if call == nil {
+		return c.writeRequest(request, thriftStruct)
 	}
-	if err := c.conn.WriteMessageEnd(); err != nil {
+
+	call.method = request.ServiceMethod
+	call.seq = int32(request.Seq)
+	call.request = thriftStruct
+	h, hasHeaders := c.conn.(HeaderReadWriter)
+	if !hasHeaders {
+		call.headers = nil
+	} else if call.headers == nil && len(c.interceptors) > 0 {
+		call.headers = &Headers{}
+	}
+	if call.headers != nil && call.headers.Write == nil {
+		call.headers.Write = make(map[string]string)
+	}
+	if _, err := c.interceptors.before(call.method, call.seq, thriftStruct, call.headers); err != nil {
+		// net/rpc fails the call with the error as is
 		return err
 	}
-	if err := c.conn.Flush(); err != nil {
+	if call.headers != nil {
+		setHeaders(h.WriteHeaders(), call.headers.Write)
+	}
+	c.mu.Lock()
+	c.calls[request.Seq] = call
+	c.mu.Unlock()
+	if err := c.writeRequest(request, thriftStruct); err != nil {
+		c.takeCall(request.Seq)
+		c.interceptors.after(call.method, call.seq, thriftStruct, nil, err)
 		return err
 	}
+	return nil
+}
+
+func (c *clientCodec) writeRequest(request *rpc.Request, thriftStruct interface{}) error {
+	if err := c.writeMessage(request, thriftStruct); err != nil {
+		return timeoutError(err, true)
+	}
 	ow := false
 	if o, ok := thriftStruct.(oneway); ok {
 		ow = o.Oneway()
 	}
+	if !ow {
+		c.mu.Lock()
+		c.outstanding++
+		c.mu.Unlock()
+	}
 	if c.enableOneway {
 		var err error
 		if ow {
@@ -121,46 +239,122 @@ func (c *clientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{
 	return nil
 }
 
+func (c *clientCodec) writeMessage(request *rpc.Request, thriftStruct interface{}) error {
+	if err := c.conn.WriteMessageBegin(request.ServiceMethod, MessageTypeCall, int32(request.Seq)); err != nil {
+		return err
+	}
+	if err := EncodeStruct(c.conn, thriftStruct); err != nil {
+		return err
+	}
+	if err := c.conn.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return c.conn.Flush()
+}
+
 func (c *clientCodec) ReadResponseHeader(response *rpc.Response) error {
 	if c.enableOneway {
 		select {
 		case ow := <-c.onewayRequests:
 			response.ServiceMethod = ow.method
 			response.Seq = ow.seq
+			c.response = c.takeCall(ow.seq)
 			return nil
 		case _ = <-c.twowayRequests:
 		}
 	}
 
-	name, messageType, seq, err := c.conn.ReadMessageBegin()
+	name, messageType, seq, err := c.readMessageBegin()
 	if err != nil {
-		return err
+		return timeoutError(err, false)
 	}
 	response.ServiceMethod = name
 	response.Seq = uint64(seq)
+	c.response = c.takeCall(response.Seq)
+	if h, ok := c.conn.(HeaderReadWriter); ok && c.response != nil && c.response.headers != nil {
+		c.response.headers.Read = cloneHeaders(h.ReadHeaders())
+	}
+	c.mu.Lock()
+	c.outstanding--
+	c.mu.Unlock()
 	if messageType == MessageTypeException {
 		exception := &ApplicationException{}
 		if err := DecodeStruct(c.conn, exception); err != nil {
-			return err
+			return timeoutError(err, false)
 		}
+		if c.response != nil && c.response.net != nil {
+			c.response.net.exception = exception
+		}
+		c.exception = exception
 		response.Error = exception.String()
-		return c.conn.ReadMessageEnd()
+		return timeoutError(c.conn.ReadMessageEnd(), false)
 	}
 	return nil
 }
 
+// readMessageBegin waits for the next response. An idle timeout while
+// there were no requests awaiting a response only means the connection is
+// idle so the read is tried again. Any other timeout closes the connection
+// as it may have left it part way through a message.
+func (c *clientCodec) readMessageBegin() (name string, messageType byte, seq int32, err error) {
+	for {
+		c.mu.Lock()
+		waiting := c.outstanding > 0
+		c.mu.Unlock()
+		name, messageType, seq, err = c.conn.ReadMessageBegin()
+		if err == ErrIdleTimeout && !waiting {
+			continue
+		}
+		if isTimeout(err) {
+			if err == ErrIdleTimeout {
+				err = ErrReadTimeout
+			}
+			c.Close()
+		}
+		return
+	}
+}
+
+// takeCall removes and returns the call with the given sequence ID or nil
+// if it isn't tracked.
+func (c *clientCodec) takeCall(seq uint64) *clientCall {
+	c.mu.Lock()
+	call := c.calls[seq]
+	delete(c.calls, seq)
+	c.mu.Unlock()
+	return call
+}
+
 func (c *clientCodec) ReadResponseBody(thriftStruct interface{}) error {
+	call, exception := c.response, c.exception
+	c.response, c.exception = nil, nil
+
+	var err error
 	if thriftStruct == nil {
 		// Should only get called if ReadResponseHeader set the Error value in
 		// which case we've already read the body (ApplicationException)
+		if exception != nil {
+			err = exception
+		}
+		if call != nil {
+			c.interceptors.after(call.method, call.seq, call.request, nil, err)
+		}
 		return nil
 	}
 
-	if err := DecodeStruct(c.conn, thriftStruct); err != nil {
-		return err
+	err = DecodeStruct(c.conn, thriftStruct)
+	if err == nil {
+		err = c.conn.ReadMessageEnd()
 	}
-
-	return c.conn.ReadMessageEnd()
+	err = timeoutError(err, false)
+	if call != nil {
+		if err != nil {
+			c.interceptors.after(call.method, call.seq, call.request, nil, err)
+		} else {
+			c.interceptors.after(call.method, call.seq, call.request, thriftStruct, nil)
+		}
+	}
+	return err
 }
 
 func (c *clientCodec) Close() error {
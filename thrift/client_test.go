@@ -5,6 +5,7 @@
 package thrift
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -202,16 +203,23 @@ func TestRPCClientOneWay(t *testing.T) {
 func TestRPCClientFail(t *testing.T) {
 	once.Do(startServer)
 
-	c, err := Dial("tcp", serverAddr, true, BinaryProtocol, false)
+	c, err := DialNetRPCClient("tcp", serverAddr, true, BinaryProtocol, false)
 	if err != nil {
 		t.Fatalf("NewClient returned error: %+v", err)
 	}
 	req := &TestRequest{123}
 	res := &TestResponse{789}
+	var ex *ApplicationException
 	if err := c.Call("Fail", req, res); err == nil {
 		t.Fatalf("Client.Call didn't return an error as it should")
 	} else if err.Error() != "Internal Error: fail" {
 		t.Fatalf("Expected 'fail' error but got '%s'", err)
+	} else if !errors.As(err, &ex) || ex.Type != ExceptionInternalError {
+		t.Fatalf("Expected an internal error ApplicationException instead of %#v", err)
+	}
+
+	if err := c.Call("Missing", req, res); !errors.As(err, &ex) || ex.Type != ExceptionUnknownMethod {
+		t.Fatalf("Expected an unknown method ApplicationException instead of %#v", err)
 	}
 
 	// Make sure an exception doesn't cause future requests to fail
@@ -222,6 +230,16 @@ func TestRPCClientFail(t *testing.T) {
 	if res.Value != req.Value {
 		t.Fatalf("Response value wrong: %d != %d", res.Value, req.Value)
 	}
+
+	// A plain rpc.Client returns the exception as an rpc.ServerError
+	rc, err := Dial("tcp", serverAddr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatalf("Dial returned error: %+v", err)
+	}
+	defer rc.Close()
+	if err := rc.Call("Fail", req, res); err != rpc.ServerError("Internal Error: fail") {
+		t.Fatalf("Expected an rpc.ServerError instead of %#v", err)
+	}
 }
 
 func TestRPCMallocCount(t *testing.T) {
@@ -241,3 +259,60 @@ func TestRPCMallocCount(t *testing.T) {
 	fmt.Printf("mallocs per thrift.rpc round trip: %d\n", int(allocs))
 	runtime.GC()
 }
+
+type TestOnewayService struct {
+	TestService
+	values chan int32
+}
+
+func (s *TestOnewayService) Notify(req *TestOneWayRequest, res *struct{}) error {
+	s.values <- req.Value
+	return nil
+}
+
+func TestRPCClientOneWayServer(t *testing.T) {
+	svc := &TestOnewayService{values: make(chan int32, 1)}
+	s := NewServer()
+	s.Register(svc)
+	l, addr := listenTCP()
+	go s.Serve(l)
+	defer s.Shutdown(context.Background())
+
+	c, err := Dial("tcp", addr, true, BinaryProtocol, true)
+	if err != nil {
+		t.Fatalf("NewClient returned error: %+v", err)
+	}
+	defer c.Close()
+	if err := c.Call("Notify", &TestOneWayRequest{123}, nil); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if v := <-svc.values; v != 123 {
+		t.Fatalf("One-way handler got %d instead of 123", v)
+	}
+
+	// The server must not have replied to the one-way request
+	req := &TestRequest{456}
+	res := &TestResponse{}
+	if err := c.Call("Success", req, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if res.Value != req.Value {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, req.Value)
+	}
+
+	// Requests sent with the Oneway message type
+	cc, err := DialContext(context.Background(), "tcp", addr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatalf("DialContext returned error: %+v", err)
+	}
+	defer cc.Close()
+	if err := cc.Call(context.Background(), "Notify", &TestOneWayRequest{789}, nil); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if v := <-svc.values; v != 789 {
+		t.Fatalf("One-way handler got %d instead of 789", v)
+	}
+	if err := cc.Call(context.Background(), "Success", req, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+}
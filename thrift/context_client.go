@@ -0,0 +1,330 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is the error returned by calls made on a Client that has
+// been closed or whose connection has failed.
+var ErrClientClosed = errors.New("thrift.client: client closed")
+
+// Client is a Thrift RPC client that doesn't depend on net/rpc. Calls may
+// be made concurrently. They're pipelined over the connection and responses
+// are matched to requests by sequence ID.
+type Client struct {
+	conn         Transport
+	interceptors interceptorChain
+	wlock        chan struct{} // held while writing a request
+
+	mu      sync.Mutex // protects the following
+	seq     int32
+	pending map[int32]*pendingCall
+	err     error
+}
+
+type pendingCall struct {
+	response interface{}
+	done     chan error
+}
+
+// DialContext connects to a Thrift RPC server at the specified network
+// address using the specified protocol and returns a Client. The
+// interceptors are called around each call.
+func DialContext(ctx context.Context, network, address string, framed bool, protocol ProtocolBuilder, interceptors ...Interceptor) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	var c io.ReadWriteCloser = conn
+	if framed {
+		c = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
+	}
+	return NewContextClient(NewTransport(c, protocol), interceptors...), nil
+}
+
+// NewContextClient returns a new Client making calls over conn. The client
+// reads responses in its own goroutine until it's closed. The interceptors
+// are called around each call.
+func NewContextClient(conn Transport, interceptors ...Interceptor) *Client {
+	c := &Client{
+		conn:         conn,
+		interceptors: interceptors,
+		wlock:        make(chan struct{}, 1),
+		pending:      make(map[int32]*pendingCall),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Call invokes the named method and waits for the response to be decoded
+// into response. Requests that implement Oneway() bool returning true are
+// sent as one-way messages and Call returns once the request is written.
+// An ApplicationException sent by the server is returned as the error.
+//
+// If ctx is done before the response arrives Call returns ctx.Err(). The
+// connection remains usable and the late response is discarded. If it's
+// done while the request is being written the write is given up, on
+// transports that support deadlines, and as part of the request may have
+// been sent the client fails. A request that can't be encoded only fails
+// its own call.
+func (c *Client) Call(ctx context.Context, method string, request interface{}, response interface{}) error {
+	ow := false
+	if o, ok := request.(oneway); ok {
+		ow = o.Oneway()
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.seq++
+	seq := c.seq
+	var call *pendingCall
+	if !ow {
+		call = &pendingCall{response: response, done: make(chan error, 1)}
+		c.pending[seq] = call
+	}
+	c.mu.Unlock()
+
+	n, err := c.interceptors.before(method, seq, request, nil)
+	if err != nil {
+		c.abandon(seq, call)
+		return err
+	}
+	err = c.send(ctx, method, seq, ow, request, call)
+	if n > 0 {
+		if err != nil || ow {
+			c.interceptors.after(method, seq, request, nil, err)
+		} else {
+			c.interceptors.after(method, seq, request, response, nil)
+		}
+	}
+	return err
+}
+
+// send writes the request and waits for the response to the call if
+// there is one.
+func (c *Client) send(ctx context.Context, method string, seq int32, ow bool, request interface{}, call *pendingCall) error {
+	// Encode the request first so an invalid one only fails this call
+	buf, err := encodeRequest(method, seq, ow, request)
+	if err != nil {
+		c.abandon(seq, call)
+		return err
+	}
+
+	select {
+	case c.wlock <- struct{}{}:
+	case <-ctx.Done():
+		c.abandon(seq, call)
+		return ctx.Err()
+	}
+	err = c.writeRequest(ctx, buf)
+	<-c.wlock
+	if err != nil {
+		// A partially written request leaves the connection unusable
+		c.fail(err)
+		c.conn.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			// The write hit the deadline before ctx was marked done
+			return context.DeadlineExceeded
+		}
+		return err
+	}
+	if ow {
+		return nil
+	}
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		if c.abandon(seq, call) {
+			return ctx.Err()
+		}
+		// The response is already being decoded so wait for it to
+		// finish rather than returning while response is written to.
+		return <-call.done
+	}
+}
+
+// abandon stops waiting for the response to call. It returns false if the
+// response is already being handled.
+func (c *Client) abandon(seq int32, call *pendingCall) bool {
+	if call == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[seq] == call {
+		delete(c.pending, seq)
+		return true
+	}
+	return false
+}
+
+// WithContext returns an RPCClient that makes calls on c using ctx. It
+// lets generated clients, which don't take a context, use a Client.
+func (c *Client) WithContext(ctx context.Context) RPCClient {
+	return &contextRPCClient{c, ctx}
+}
+
+// Close closes the connection. Pending calls return ErrClientClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClientClosed)
+	return c.conn.Close()
+}
+
+// encodeRequest returns the request message in a buffer to be written by
+// writeRequest.
+func encodeRequest(method string, seq int32, ow bool, request interface{}) (*protocolBuffer, error) {
+	mtype := byte(MessageTypeCall)
+	if ow {
+		mtype = MessageTypeOneway
+	}
+	buf := &protocolBuffer{}
+	buf.WriteMessageBegin(method, mtype, seq)
+	if err := EncodeStruct(buf, request); err != nil {
+		return nil, err
+	}
+	buf.WriteMessageEnd()
+	return buf, nil
+}
+
+// writeDeadliner is implemented by the transports returned by NewTransport
+// and NewTimeoutTransport.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// writeRequest writes a request to the connection. If the connection
+// supports deadlines the write is given up once ctx is done.
+func (c *Client) writeRequest(ctx context.Context, buf *protocolBuffer) error {
+	d, ok := c.conn.(writeDeadliner)
+	if ok && ctx.Done() != nil {
+		deadline, _ := ctx.Deadline()
+		ok = d.SetWriteDeadline(deadline) == nil
+	}
+	if ok && ctx.Done() != nil {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				// Interrupt the write
+				d.SetWriteDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+			d.SetWriteDeadline(time.Time{})
+		}()
+	}
+	if err := buf.writeTo(c.conn); err != nil {
+		return timeoutError(err, true)
+	}
+	return timeoutError(c.conn.Flush(), true)
+}
+
+func (c *Client) readLoop() {
+	var err error
+	for err == nil {
+		err = c.readResponse()
+	}
+	c.fail(timeoutError(err, false))
+	if isTimeout(err) {
+		// The read may have stopped part way through a message
+		c.conn.Close()
+	}
+}
+
+func (c *Client) readResponse() error {
+	c.mu.Lock()
+	waiting := len(c.pending) > 0
+	c.mu.Unlock()
+	_, mtype, seq, err := c.conn.ReadMessageBegin()
+	if err != nil {
+		if !waiting && err == ErrIdleTimeout {
+			// Only idle, not waiting on a response
+			return nil
+		}
+		if err == ErrIdleTimeout {
+			err = ErrReadTimeout
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	call := c.pending[seq]
+	delete(c.pending, seq)
+	c.mu.Unlock()
+
+	switch {
+	case call == nil:
+		// Abandoned call or unknown sequence ID
+		if err := SkipValue(c.conn, TypeStruct); err != nil {
+			return err
+		}
+	case mtype == MessageTypeException:
+		exception := &ApplicationException{}
+		if err := DecodeStruct(c.conn, exception); err != nil {
+			err = timeoutError(err, false)
+			call.done <- err
+			return err
+		}
+		call.done <- exception
+	case mtype == MessageTypeReply:
+		if err := DecodeStruct(c.conn, call.response); err != nil {
+			err = timeoutError(err, false)
+			call.done <- err
+			return err
+		}
+		call.done <- nil
+	default:
+		err := ProtocolError{"Client", "unexpected message type"}
+		call.done <- err
+		return err
+	}
+	return c.conn.ReadMessageEnd()
+}
+
+// fail marks the client as unusable and fails all pending calls.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+	}
+	for seq, call := range c.pending {
+		call.done <- c.err
+		delete(c.pending, seq)
+	}
+}
+
+type contextRPCClient struct {
+	c   *Client
+	ctx context.Context
+}
+
+func (c *contextRPCClient) Call(method string, request interface{}, response interface{}) error {
+	return c.c.Call(c.ctx, method, request, response)
+}
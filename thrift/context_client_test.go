@@ -0,0 +1,206 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestContextClient(t *testing.T) {
+	once.Do(startServer)
+
+	c, err := DialContext(context.Background(), "tcp", serverAddr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatalf("DialContext returned error: %+v", err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := int32(0); i < 10; i++ {
+		wg.Add(1)
+		go func(v int32) {
+			defer wg.Done()
+			res := &TestResponse{}
+			if err := c.Call(context.Background(), "Success", &TestRequest{v}, res); err != nil {
+				t.Errorf("Client.Call returned error: %+v", err)
+			} else if res.Value != v {
+				t.Errorf("Response value wrong: %d != %d", res.Value, v)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	err = c.Call(context.Background(), "Fail", &TestRequest{1}, &TestResponse{})
+	if ex, ok := err.(*ApplicationException); !ok {
+		t.Fatalf("Expected an ApplicationException instead of %+v", err)
+	} else if ex.Type != ExceptionInternalError || ex.Message != "fail" {
+		t.Fatalf("Unexpected exception %+v", ex)
+	}
+
+	// Generated clients use the RPCClient interface
+	res := &TestResponse{}
+	if err := NewMultiplexedClient(c.WithContext(context.Background()), "Thrift").Call("Success", &TestRequest{5}, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	} else if res.Value != 5 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, 5)
+	}
+}
+
+func TestContextClientTimeout(t *testing.T) {
+	cli, srv := net.Pipe()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol))
+	defer c.Close()
+
+	// The server only replies once it has both requests so the first
+	// call times out and its response has to be discarded.
+	go func() {
+		st := NewTransport(srv, BinaryProtocol)
+		var seqs []int32
+		for i := 0; i < 2; i++ {
+			_, _, seq, err := st.ReadMessageBegin()
+			if err != nil {
+				return
+			}
+			req := &TestRequest{}
+			if err := DecodeStruct(st, req); err != nil {
+				return
+			}
+			st.ReadMessageEnd()
+			seqs = append(seqs, seq)
+		}
+		for _, seq := range seqs {
+			st.WriteMessageBegin("Success", MessageTypeReply, seq)
+			EncodeStruct(st, &TestResponse{seq})
+			st.WriteMessageEnd()
+			st.Flush()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "Success", &TestRequest{1}, &TestResponse{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded instead of %+v", err)
+	}
+
+	res := &TestResponse{}
+	if err := c.Call(context.Background(), "Success", &TestRequest{2}, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if res.Value != 2 {
+		t.Fatalf("Expected the response for seqid 2 instead of %d", res.Value)
+	}
+}
+
+func TestContextClientOneway(t *testing.T) {
+	cli, srv := net.Pipe()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol))
+	defer c.Close()
+
+	mtypes := make(chan byte, 1)
+	go func() {
+		st := NewTransport(srv, BinaryProtocol)
+		_, mtype, _, err := st.ReadMessageBegin()
+		if err != nil {
+			return
+		}
+		mtypes <- mtype
+	}()
+
+	if err := c.Call(context.Background(), "Success", &TestOneWayRequest{1}, nil); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if mtype := <-mtypes; mtype != MessageTypeOneway {
+		t.Fatalf("Expected message type %d instead of %d", MessageTypeOneway, mtype)
+	}
+}
+
+func TestContextClientClose(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol))
+
+	// Drain the request but never reply
+	go io.Copy(ioutil.Discard, srv)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-errs; err != ErrClientClosed {
+		t.Fatalf("Expected ErrClientClosed instead of %+v", err)
+	}
+	if err := c.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{}); err != ErrClientClosed {
+		t.Fatalf("Expected ErrClientClosed instead of %+v", err)
+	}
+}
+
+func TestContextClientEncodeError(t *testing.T) {
+	once.Do(startServer)
+
+	c, err := DialContext(context.Background(), "tcp", serverAddr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatalf("DialContext returned error: %+v", err)
+	}
+	defer c.Close()
+
+	// An invalid request fails without affecting the connection
+	var mf *MissingRequiredField
+	if err := c.Call(context.Background(), "Success", &TestStructRequiredOptional{}, &TestResponse{}); !errors.As(err, &mf) {
+		t.Fatalf("Expected MissingRequiredField instead of %+v", err)
+	}
+	res := &TestResponse{}
+	if err := c.Call(context.Background(), "Success", &TestRequest{3}, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	} else if res.Value != 3 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, 3)
+	}
+}
+
+func TestContextClientWriteContext(t *testing.T) {
+	for _, deadline := range []bool{true, false} {
+		cli, srv := net.Pipe()
+		// Nothing reads from srv so writes block
+		c := NewContextClient(NewTransport(cli, BinaryProtocol))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		expected := context.Canceled
+		if deadline {
+			ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+			expected = context.DeadlineExceeded
+		} else {
+			time.AfterFunc(20*time.Millisecond, cancel)
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- c.Call(ctx, "Success", &TestRequest{1}, &TestResponse{})
+		}()
+		select {
+		case err := <-errs:
+			if err != expected {
+				t.Fatalf("Expected %+v instead of %+v", expected, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Call blocked writing to a stalled connection")
+		}
+		cancel()
+
+		// The partially written request leaves the client unusable but
+		// doesn't block other callers
+		if err := c.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{}); err == nil {
+			t.Fatal("Expected the client to have failed")
+		}
+		c.Close()
+		srv.Close()
+	}
+}
@@ -5,6 +5,8 @@
 package thrift
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"runtime"
 )
@@ -14,26 +16,66 @@ type Decoder interface {
 	DecodeThrift(ProtocolReader) error
 }
 
+// DecodeOptions relax how DecodeStructWithOptions treats values whose
+// type on the wire doesn't match the struct, e.g. after a producer widened
+// a field from i32 to i64, and change how errors are reported. The zero
+// value behaves like DecodeStruct.
+type DecodeOptions struct {
+	// CoerceTypes converts integers between byte, i16, i32 and i64 as long
+	// as the value fits in the Go type and the Thrift type it's encoded
+	// as, e.g. i32 for an int, and reads lists into sets and sets into
+	// lists. A value that doesn't fit is an error. Strings and binary
+	// already share a type on the wire so need no conversion.
+	CoerceTypes bool
+	// SkipMismatched skips fields whose type can't be converted, and
+	// leaves integers that don't fit as zero, instead of failing. With
+	// CoerceTypes, list, set and map elements are checked and skipped
+	// too.
+	SkipMismatched bool
+	// OnMismatch is called with each field or value SkipMismatched
+	// skipped. The error's Path, ExpectedType and ActualType describe it.
+	OnMismatch func(*DecodeError)
+	// ErrorPaths wraps errors in a DecodeError giving the path to the
+	// field being decoded when they happened.
+	ErrorPaths bool
+}
+
 type decoder struct {
-	r ProtocolReader
+	r    ProtocolReader
+	opts DecodeOptions
+	root reflect.Value
+	path valuePath
+	// expected and actual are the types of a mismatched field
+	expected byte
+	actual   byte
 }
 
 // DecodeStruct tries to deserialize a struct from a Thrift stream
-func DecodeStruct(r ProtocolReader, v interface{}) (err error) {
+func DecodeStruct(r ProtocolReader, v interface{}) error {
+	return DecodeStructWithOptions(r, v, DecodeOptions{})
+}
+
+// DecodeStructWithOptions is like DecodeStruct but with options that
+// tolerate type mismatches or add paths to errors. Types implementing
+// Decoder ignore them.
+func DecodeStructWithOptions(r ProtocolReader, v interface{}, opts DecodeOptions) (err error) {
 	if de, ok := v.(Decoder); ok {
 		return de.DecodeThrift(r)
 	}
 
+	vo := reflect.ValueOf(v)
+	d := &decoder{r: r, opts: opts, root: vo}
 	defer func() {
 		if r := recover(); r != nil {
 			if _, ok := r.(runtime.Error); ok {
 				panic(r)
 			}
 			err = r.(error)
+			if d.opts.ErrorPaths {
+				err = d.wrapError(vo, err)
+			}
 		}
 	}()
-	d := &decoder{r}
-	vo := reflect.ValueOf(v)
 	for vo.Kind() != reflect.Ptr {
 		d.error(&UnsupportedValueError{Value: vo, Str: "pointer to struct expected"})
 	}
@@ -48,6 +90,159 @@ func (d *decoder) error(err interface{}) {
 	panic(err)
 }
 
+// wrapError returns err in a DecodeError with the current path.
+func (d *decoder) wrapError(v reflect.Value, err error) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	return &DecodeError{
+		Path:         d.path.format(valueType(v)),
+		ExpectedType: d.expected,
+		ActualType:   d.actual,
+		Offset:       -1,
+		Err:          err,
+	}
+}
+
+// coercible returns true if a value of type actual can be read into a
+// field of type expected.
+func (d *decoder) coercible(actual, expected byte) bool {
+	if !d.opts.CoerceTypes {
+		return false
+	}
+	if isIntType(actual) && isIntType(expected) {
+		return true
+	}
+	return (actual == TypeList || actual == TypeSet) && (expected == TypeList || expected == TypeSet)
+}
+
+// elemMatches returns true if a list, set or map element of type actual
+// can be read into a value of type t. Unlike fields, elements can't be
+// tagged as sets so lists and sets can be read into any slice or map.
+// Elements are only checked with CoerceTypes so without it they're read
+// as DecodeStruct always has.
+func (d *decoder) elemMatches(actual byte, t reflect.Type) bool {
+	if !d.opts.CoerceTypes {
+		return true
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		// Left for readValue to reject
+		return true
+	}
+	expected := fieldType(t)
+	if actual == expected || d.coercible(actual, expected) {
+		return true
+	}
+	return (actual == TypeList || actual == TypeSet) && (expected == TypeList || t.Kind() == reflect.Map)
+}
+
+// skipMismatched skips a value of type actual that can't be read into v
+// of type expected, or fails if SkipMismatched isn't set.
+func (d *decoder) skipMismatched(actual, expected byte, v reflect.Value) {
+	d.expected, d.actual = expected, actual
+	err := &UnsupportedValueError{Value: v, Str: "type mismatch"}
+	if !d.opts.SkipMismatched {
+		d.error(err)
+	}
+	if err := SkipValue(d.r, actual); err != nil {
+		d.error(err)
+	}
+	d.mismatch(err)
+}
+
+// mismatch reports a value that was skipped because of err.
+func (d *decoder) mismatch(err error) {
+	if d.opts.OnMismatch != nil {
+		d.opts.OnMismatch(d.wrapError(d.root, err).(*DecodeError))
+	}
+	d.expected, d.actual = TypeStop, TypeStop
+}
+
+// readInt reads an integer of any width.
+func (d *decoder) readInt(thriftType byte) int64 {
+	var x int64
+	var err error
+	switch thriftType {
+	case TypeByte:
+		var b byte
+		b, err = d.r.ReadByte()
+		x = int64(int8(b))
+	case TypeI16:
+		var i int16
+		i, err = d.r.ReadI16()
+		x = int64(i)
+	case TypeI32:
+		var i int32
+		i, err = d.r.ReadI32()
+		x = int64(i)
+	case TypeI64:
+		x, err = d.r.ReadI64()
+	}
+	if err != nil {
+		d.error(err)
+	}
+	return x
+}
+
+// readCoercedInt reads an integer of type thriftType into v which holds
+// an integer of a different width.
+func (d *decoder) readCoercedInt(thriftType byte, v reflect.Value) {
+	x := d.readInt(thriftType)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// An int is encoded as an i32 so must fit in one
+		if !v.OverflowInt(x) && !overflowsIntType(fieldType(v.Type()), x) {
+			v.SetInt(x)
+			return
+		}
+	default:
+		if x >= 0 && !v.OverflowUint(uint64(x)) {
+			v.SetUint(uint64(x))
+			return
+		}
+	}
+	d.expected, d.actual = fieldType(v.Type()), thriftType
+	err := &UnsupportedValueError{Value: v, Str: fmt.Sprintf("%d overflows %s", x, v.Type())}
+	if !d.opts.SkipMismatched {
+		d.error(err)
+	}
+	d.mismatch(err)
+}
+
+// overflowsIntType returns true if x doesn't fit in an integer of type
+// thriftType.
+func overflowsIntType(thriftType byte, x int64) bool {
+	switch thriftType {
+	case TypeByte:
+		return x < math.MinInt8 || x > math.MaxInt8
+	case TypeI16:
+		return x < math.MinInt16 || x > math.MaxInt16
+	case TypeI32:
+		return x < math.MinInt32 || x > math.MaxInt32
+	}
+	return false
+}
+
+func isIntType(thriftType byte) bool {
+	switch thriftType {
+	case TypeByte, TypeI16, TypeI32, TypeI64:
+		return true
+	}
+	return false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
 func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 	v := rf
 	kind := rf.Kind()
@@ -66,6 +261,11 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 		return
 	}
 
+	if d.opts.CoerceTypes && isIntType(thriftType) && isIntKind(kind) && fieldType(v.Type()) != thriftType {
+		d.readCoercedInt(thriftType, v)
+		return
+	}
+
 	var err error
 	switch thriftType {
 	case TypeBool:
@@ -141,8 +341,21 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 			d.error(err)
 		}
 
-		meta := encodeFields(v.Type())
-		req := meta.required
+		meta, err := encodeFields(v.Type())
+		if err != nil {
+			d.error(err)
+		}
+		// The cached set of required fields is shared so track the ones
+		// still to be read in a copy
+		var req BitSet
+		if !meta.required.IsEmpty() {
+			req = append(req, *meta.required...)
+		}
+		if meta.unknown != nil {
+			if unknown := fieldByIndex(v, meta.unknown); unknown.IsValid() {
+				unknown.Set(reflect.Zero(unknownFieldsType))
+			}
+		}
 		for {
 			ftype, id, err := d.r.ReadFieldBegin()
 			if err != nil {
@@ -153,16 +366,28 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 			}
 
 			ef, ok := meta.fields[int(id)]
+			d.path.push(pathElem{kind: pathField, id: id})
 			if !ok {
-				SkipValue(d.r, ftype)
+				if meta.unknown != nil {
+					f, err := readUnknownField(d.r, id, ftype)
+					if err != nil {
+						d.error(err)
+					}
+					unknown := fieldByIndexAlloc(v, meta.unknown)
+					unknown.Set(reflect.Append(unknown, reflect.ValueOf(f)))
+				} else if err := SkipValue(d.r, ftype); err != nil {
+					d.error(err)
+				}
 			} else {
 				req.Clear(id)
-				fieldValue := v.Field(ef.i)
-				if ftype != ef.fieldType {
-					d.error(&UnsupportedValueError{Value: fieldValue, Str: "type mismatch"})
+				fieldValue := fieldByIndexAlloc(v, ef.index)
+				if ftype != ef.fieldType && !d.coercible(ftype, ef.fieldType) {
+					d.skipMismatched(ftype, ef.fieldType, fieldValue)
+				} else {
+					d.readValue(ftype, fieldValue)
 				}
-				d.readValue(ftype, fieldValue)
 			}
+			d.path.pop()
 
 			if err = d.r.ReadFieldEnd(); err != nil {
 				d.error(err)
@@ -176,6 +401,7 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 		if !req.IsEmpty() {
 			for i := 0; !req.IsEmpty(); i++ {
 				if req.IsSet(i) {
+					d.path.push(pathElem{kind: pathField, id: int16(i)})
 					d.error(&MissingRequiredField{
 						StructName: v.Type().Name(),
 						FieldName:  meta.fields[i].name,
@@ -192,68 +418,49 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 			d.error(err)
 		}
 		v.Set(reflect.MakeMap(v.Type()))
+		keyMatches := d.elemMatches(ktype, keyType)
+		valueMatches := d.elemMatches(vtype, valueType)
 		for i := 0; i < n; i++ {
 			key := reflect.New(keyType).Elem()
 			val := reflect.New(valueType).Elem()
-			d.readValue(ktype, key)
-			d.readValue(vtype, val)
-			v.SetMapIndex(key, val)
+			d.path.push(pathElem{kind: pathMapKey, index: i})
+			if keyMatches {
+				d.readValue(ktype, key)
+			} else {
+				d.skipMismatched(ktype, fieldType(keyType), key)
+			}
+			d.path.pop()
+			d.path.push(pathElem{kind: pathMapValue, index: i})
+			if valueMatches {
+				d.readValue(vtype, val)
+			} else {
+				d.skipMismatched(vtype, fieldType(valueType), val)
+			}
+			d.path.pop()
+			if keyMatches && valueMatches {
+				v.SetMapIndex(key, val)
+			}
 		}
 		if err := d.r.ReadMapEnd(); err != nil {
 			d.error(err)
 		}
 	case TypeList:
-		elemType := v.Type().Elem()
 		et, n, err := d.r.ReadListBegin()
 		if err != nil {
 			d.error(err)
 		}
-		for i := 0; i < n; i++ {
-			val := reflect.New(elemType)
-			d.readValue(et, val.Elem())
-			v.Set(reflect.Append(v, val.Elem()))
-		}
+		d.readElems(et, n, v)
 		if err := d.r.ReadListEnd(); err != nil {
 			d.error(err)
 		}
 	case TypeSet:
-		if v.Type().Kind() == reflect.Slice {
-			elemType := v.Type().Elem()
-			et, n, err := d.r.ReadSetBegin()
-			if err != nil {
-				d.error(err)
-			}
-			for i := 0; i < n; i++ {
-				val := reflect.New(elemType)
-				d.readValue(et, val.Elem())
-				v.Set(reflect.Append(v, val.Elem()))
-			}
-			if err := d.r.ReadSetEnd(); err != nil {
-				d.error(err)
-			}
-		} else if v.Type().Kind() == reflect.Map {
-			elemType := v.Type().Key()
-			valueType := v.Type().Elem()
-			et, n, err := d.r.ReadSetBegin()
-			if err != nil {
-				d.error(err)
-			}
-			v.Set(reflect.MakeMap(v.Type()))
-			for i := 0; i < n; i++ {
-				key := reflect.New(elemType).Elem()
-				d.readValue(et, key)
-				switch valueType.Kind() {
-				case reflect.Bool:
-					v.SetMapIndex(key, reflect.ValueOf(true))
-				default:
-					v.SetMapIndex(key, reflect.Zero(valueType))
-				}
-			}
-			if err := d.r.ReadSetEnd(); err != nil {
-				d.error(err)
-			}
-		} else {
-			d.error(&UnsupportedTypeError{v.Type()})
+		et, n, err := d.r.ReadSetBegin()
+		if err != nil {
+			d.error(err)
+		}
+		d.readElems(et, n, v)
+		if err := d.r.ReadSetEnd(); err != nil {
+			d.error(err)
 		}
 	default:
 		d.error(&UnsupportedTypeError{v.Type()})
@@ -265,3 +472,49 @@ func (d *decoder) readValue(thriftType byte, rf reflect.Value) {
 
 	return
 }
+
+// readElems reads the n elements of a list or set into v which is either a
+// slice or a map used as a set.
+func (d *decoder) readElems(et byte, n int, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		elemType := v.Type().Elem()
+		matches := d.elemMatches(et, elemType)
+		for i := 0; i < n; i++ {
+			val := reflect.New(elemType)
+			d.path.push(pathElem{kind: pathIndex, index: i})
+			if !matches {
+				d.skipMismatched(et, fieldType(elemType), val.Elem())
+				d.path.pop()
+				continue
+			}
+			d.readValue(et, val.Elem())
+			d.path.pop()
+			v.Set(reflect.Append(v, val.Elem()))
+		}
+	case reflect.Map:
+		elemType := v.Type().Key()
+		valueType := v.Type().Elem()
+		v.Set(reflect.MakeMap(v.Type()))
+		matches := d.elemMatches(et, elemType)
+		for i := 0; i < n; i++ {
+			key := reflect.New(elemType).Elem()
+			d.path.push(pathElem{kind: pathIndex, index: i})
+			if !matches {
+				d.skipMismatched(et, fieldType(elemType), key)
+				d.path.pop()
+				continue
+			}
+			d.readValue(et, key)
+			d.path.pop()
+			switch valueType.Kind() {
+			case reflect.Bool:
+				v.SetMapIndex(key, reflect.ValueOf(true))
+			default:
+				v.SetMapIndex(key, reflect.Zero(valueType))
+			}
+		}
+	default:
+		d.error(&UnsupportedTypeError{v.Type()})
+	}
+}
@@ -14,26 +14,45 @@ type Encoder interface {
 	EncodeThrift(ProtocolWriter) error
 }
 
+// EncodeOptions changes how EncodeStructWithOptions reports errors. The
+// zero value behaves like EncodeStruct.
+type EncodeOptions struct {
+	// ErrorPaths wraps errors in an EncodeError giving the path to the
+	// field being encoded when they happened.
+	ErrorPaths bool
+}
+
 type encoder struct {
-	w ProtocolWriter
+	w    ProtocolWriter
+	opts EncodeOptions
+	path valuePath
 }
 
 // EncodeStruct tries to serialize a struct to a Thrift stream
-func EncodeStruct(w ProtocolWriter, v interface{}) (err error) {
+func EncodeStruct(w ProtocolWriter, v interface{}) error {
+	return EncodeStructWithOptions(w, v, EncodeOptions{})
+}
+
+// EncodeStructWithOptions is like EncodeStruct but with options. Types
+// implementing Encoder ignore them.
+func EncodeStructWithOptions(w ProtocolWriter, v interface{}, opts EncodeOptions) (err error) {
 	if en, ok := v.(Encoder); ok {
 		return en.EncodeThrift(w)
 	}
 
+	e := &encoder{w: w, opts: opts}
+	vo := reflect.ValueOf(v)
 	defer func() {
 		if r := recover(); r != nil {
 			if _, ok := r.(runtime.Error); ok {
 				panic(r)
 			}
 			err = r.(error)
+			if e.opts.ErrorPaths {
+				err = e.wrapError(vo, err)
+			}
 		}
 	}()
-	e := &encoder{w}
-	vo := reflect.ValueOf(v)
 	e.writeStruct(vo)
 	return nil
 }
@@ -42,6 +61,14 @@ func (e *encoder) error(err interface{}) {
 	panic(err)
 }
 
+// wrapError returns err in an EncodeError with the current path.
+func (e *encoder) wrapError(v reflect.Value, err error) error {
+	if _, ok := err.(*EncodeError); ok {
+		return err
+	}
+	return &EncodeError{Path: e.path.format(valueType(v)), Err: err}
+}
+
 func (e *encoder) writeStruct(v reflect.Value) {
 	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
 		v = v.Elem()
@@ -56,31 +83,61 @@ func (e *encoder) writeStruct(v reflect.Value) {
 		e.error(err)
 	}
 
-	mf := encodeFields(v.Type())
+	mf, err := encodeFields(v.Type())
+	if err != nil {
+		e.error(err)
+	}
+	var unknown UnknownFields
+	if mf.unknown != nil {
+		if f := fieldByIndex(v, mf.unknown); f.IsValid() {
+			unknown = sortedUnknownFields(f.Interface().(UnknownFields))
+		}
+	}
 	for _, fid := range mf.orderedIds {
+		for len(unknown) > 0 && int(unknown[0].ID) <= fid {
+			// The known field takes the place of one with the same ID
+			if int(unknown[0].ID) < fid {
+				e.writeUnknownField(unknown[0])
+			}
+			unknown = unknown[1:]
+		}
+
 		ef := mf.fields[fid]
-		structField := v.Type().Field(ef.i)
-		fieldValue := v.Field(ef.i)
+		e.path.push(pathElem{kind: pathField, id: int16(ef.id)})
+		fieldValue := fieldByIndex(v, ef.index)
+		if !fieldValue.IsValid() {
+			// In an embedded struct through a nil pointer
+			if ef.required {
+				e.error(&MissingRequiredField{v.Type().Name(), ef.name})
+			}
+			e.path.pop()
+			continue
+		}
 
 		if !ef.required && !ef.keepEmpty && isEmptyValue(fieldValue) {
+			e.path.pop()
 			continue
 		}
 
 		if fieldValue.Kind() == reflect.Ptr {
 			if ef.required && fieldValue.IsNil() {
-				e.error(&MissingRequiredField{v.Type().Name(), structField.Name})
+				e.error(&MissingRequiredField{v.Type().Name(), ef.name})
 			}
 		}
 
 		ftype := ef.fieldType
 
-		if err := e.w.WriteFieldBegin(structField.Name, ftype, int16(ef.id)); err != nil {
+		if err := e.w.WriteFieldBegin(ef.name, ftype, int16(ef.id)); err != nil {
 			e.error(err)
 		}
 		e.writeValue(fieldValue, ftype)
 		if err := e.w.WriteFieldEnd(); err != nil {
 			e.error(err)
 		}
+		e.path.pop()
+	}
+	for _, f := range unknown {
+		e.writeUnknownField(f)
 	}
 	if err := e.w.WriteFieldStop(); err != nil {
 		e.error(err)
@@ -90,6 +147,14 @@ func (e *encoder) writeStruct(v reflect.Value) {
 	}
 }
 
+func (e *encoder) writeUnknownField(f UnknownField) {
+	e.path.push(pathElem{kind: pathField, id: f.ID})
+	if err := writeUnknownField(e.w, f); err != nil {
+		e.error(err)
+	}
+	e.path.pop()
+}
+
 func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 	if en, ok := v.Interface().(Encoder); ok {
 		if err := en.EncodeThrift(e.w); err != nil {
@@ -151,9 +216,13 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 		if er := e.w.WriteMapBegin(keyThriftType, valueThriftType, v.Len()); er != nil {
 			e.error(er)
 		}
-		for _, k := range v.MapKeys() {
+		for i, k := range v.MapKeys() {
+			e.path.push(pathElem{kind: pathMapKey, index: i})
 			e.writeValue(k, keyThriftType)
+			e.path.pop()
+			e.path.push(pathElem{kind: pathMapValue, index: i})
 			e.writeValue(v.MapIndex(k), valueThriftType)
+			e.path.pop()
 		}
 		err = e.w.WriteMapEnd()
 	case TypeList:
@@ -167,7 +236,9 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 			}
 			n := v.Len()
 			for i := 0; i < n; i++ {
+				e.path.push(pathElem{kind: pathIndex, index: i})
 				e.writeValue(v.Index(i), elemThriftType)
+				e.path.pop()
 			}
 			err = e.w.WriteListEnd()
 		}
@@ -180,7 +251,9 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 			}
 			n := v.Len()
 			for i := 0; i < n; i++ {
+				e.path.push(pathElem{kind: pathIndex, index: i})
 				e.writeValue(v.Index(i), elemThriftType)
+				e.path.pop()
 			}
 			err = e.w.WriteSetEnd()
 		} else if v.Type().Kind() == reflect.Map {
@@ -197,17 +270,23 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 				if er := e.w.WriteSetBegin(elemThriftType, n); er != nil {
 					e.error(er)
 				}
+				i := 0
 				for _, k := range v.MapKeys() {
 					if v.MapIndex(k).Bool() {
+						e.path.push(pathElem{kind: pathIndex, index: i})
 						e.writeValue(k, elemThriftType)
+						e.path.pop()
+						i++
 					}
 				}
 			} else {
 				if er := e.w.WriteSetBegin(elemThriftType, v.Len()); er != nil {
 					e.error(er)
 				}
-				for _, k := range v.MapKeys() {
+				for i, k := range v.MapKeys() {
+					e.path.push(pathElem{kind: pathIndex, index: i})
 					e.writeValue(k, elemThriftType)
+					e.path.pop()
 				}
 			}
 			err = e.w.WriteSetEnd()
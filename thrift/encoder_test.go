@@ -6,8 +6,10 @@ package thrift
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -226,6 +228,41 @@ func TestDecodeRequiredFields(t *testing.T) {
 	}
 }
 
+func TestDecodeRequiredFieldsConcurrent(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := EncodeStruct(NewBinaryProtocolWriter(buf, true), &TestEmptyStruct{}); err != nil {
+		t.Fatal(err)
+	}
+	empty := buf.Bytes()
+	str := "foo"
+	full, err := Marshal(&TestStructRequiredOptional{&str, str, nil, ""}, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// Decodes that read the required fields mustn't affect
+				// ones that don't
+				if err := Unmarshal(full, &TestStructRequiredOptional{}, BinaryProtocol); err != nil {
+					t.Errorf("Expected no error instead of %+v", err)
+					return
+				}
+				err := Unmarshal(empty, &TestStructRequiredOptional{}, BinaryProtocol)
+				if e, ok := err.(*MissingRequiredField); !ok || e.FieldName != "RequiredPtr" {
+					t.Errorf("Expected MissingRequiredField for RequiredPtr instead of %+v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestDecodeUnknownFields(t *testing.T) {
 	buf := &bytes.Buffer{}
 
@@ -318,3 +355,227 @@ func BenchmarkDecodeSimpleStruct(b *testing.B) {
 		DecodeStruct(NewBinaryProtocolReader(buf, false), st)
 	}
 }
+
+type testCoerceV1 struct {
+	Byte int8               `thrift:"1"`
+	I16  int16              `thrift:"2"`
+	I32  int32              `thrift:"3"`
+	U32  uint32             `thrift:"4"`
+	Ptr  *int64             `thrift:"5"`
+	List []int16            `thrift:"6"`
+	Set  map[int32]struct{} `thrift:"7"`
+	Name string             `thrift:"8"`
+}
+
+// testCoerceV2 is testCoerceV1 with its fields' types changed
+type testCoerceV2 struct {
+	Byte int64  `thrift:"1"`
+	I16  int8   `thrift:"2"`
+	I32  int64  `thrift:"3"`
+	U32  int64  `thrift:"4"`
+	Ptr  int16  `thrift:"5"`
+	List []int8 `thrift:"6,set"`
+	Set  []int8 `thrift:"7"`
+	Name int32  `thrift:"8"`
+}
+
+func TestDecodeCoerceTypes(t *testing.T) {
+	v2 := &testCoerceV2{Byte: -3, I16: -100, I32: -1 << 31, U32: 1<<32 - 1, Ptr: 1000, List: []int8{-1, 2}, Set: []int8{5}}
+	ptr := int64(1000)
+	expected := &testCoerceV1{Byte: -3, I16: -100, I32: -1 << 31, U32: 1<<32 - 1, Ptr: &ptr, List: []int16{-1, 2}, Set: map[int32]struct{}{5: {}}}
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		v2.Name = 0
+		data, err := Marshal(v2, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Unmarshal(data, &testCoerceV1{}, p); err == nil {
+			t.Fatal("Expected a type mismatch without CoerceTypes")
+		}
+		d := NewDeserializer(p)
+		d.Options.CoerceTypes = true
+		d.Options.ErrorPaths = true
+		v1 := &testCoerceV1{}
+		if err := d.Unmarshal(data, v1); err != nil {
+			t.Fatalf("Unmarshal returned error: %+v", err)
+		}
+		if !reflect.DeepEqual(v1, expected) {
+			t.Fatalf("Expected %+v instead of %+v", expected, v1)
+		}
+
+		// Values that don't fit are an error
+		v2.U32 = -1
+		data, err = Marshal(v2, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2.U32 = 1<<32 - 1
+		err = d.Unmarshal(data, &testCoerceV1{})
+		var de *DecodeError
+		if !errors.As(err, &de) || de.Path != "testCoerceV1.U32 (field 4)" || de.ExpectedType != TypeI32 || de.ActualType != TypeI64 {
+			t.Fatalf("Expected a DecodeError for U32 instead of %+v", err)
+		}
+
+		// Types that can't be converted are still a mismatch
+		v2.Name = 1
+		data, err = Marshal(v2, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Unmarshal(data, &testCoerceV1{}); !errors.As(err, &de) || de.Path != "testCoerceV1.Name (field 8)" {
+			t.Fatalf("Expected a DecodeError for Name instead of %+v", err)
+		}
+	}
+}
+
+func TestDecodeSkipMismatched(t *testing.T) {
+	data, err := Marshal(&testCoerceV2{I16: 1, I32: 1 << 40, List: []int8{1, 2}, Name: 1}, CompactProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatched []string
+	opts := DecodeOptions{
+		CoerceTypes:    true,
+		SkipMismatched: true,
+		OnMismatch: func(err *DecodeError) {
+			mismatched = append(mismatched, fmt.Sprintf("%s %s %s", err.Path, typeName(err.ExpectedType), typeName(err.ActualType)))
+		},
+	}
+	v1 := &testCoerceV1{}
+	if err := DecodeStructWithOptions(CompactProtocol.NewProtocolReader(bytes.NewReader(data)), v1, opts); err != nil {
+		t.Fatalf("DecodeStructWithOptions returned error: %+v", err)
+	}
+	expected := &testCoerceV1{I16: 1, List: []int16{1, 2}}
+	if !reflect.DeepEqual(v1, expected) {
+		t.Fatalf("Expected %+v instead of %+v", expected, v1)
+	}
+	expectedMismatched := []string{
+		"testCoerceV1.I32 (field 3) i32 i64",
+		"testCoerceV1.Name (field 8) string i32",
+	}
+	if !reflect.DeepEqual(mismatched, expectedMismatched) {
+		t.Fatalf("Expected mismatches %q instead of %q", expectedMismatched, mismatched)
+	}
+
+	// Without CoerceTypes every changed field is skipped
+	mismatched = nil
+	opts.CoerceTypes = false
+	v1 = &testCoerceV1{}
+	if err := DecodeStructWithOptions(CompactProtocol.NewProtocolReader(bytes.NewReader(data)), v1, opts); err != nil {
+		t.Fatalf("DecodeStructWithOptions returned error: %+v", err)
+	}
+	expectedMismatched = []string{
+		"testCoerceV1.I16 (field 2) i16 byte",
+		"testCoerceV1.I32 (field 3) i32 i64",
+		"testCoerceV1.List (field 6) list set",
+		"testCoerceV1.Name (field 8) string i32",
+	}
+	if !reflect.DeepEqual(v1, &testCoerceV1{}) || !reflect.DeepEqual(mismatched, expectedMismatched) {
+		t.Fatalf("Expected every field to be skipped instead of %+v and %q", v1, mismatched)
+	}
+}
+
+type testElemsV1 struct {
+	Strs   []int32          `thrift:"1"`
+	I64s   []int32          `thrift:"2"`
+	Values map[string]int32 `thrift:"3"`
+	Keys   map[int32]int32  `thrift:"4"`
+	Int    int              `thrift:"5"`
+}
+
+// testElemsV2 is testElemsV1 with its elements' types changed
+type testElemsV2 struct {
+	Strs   []string          `thrift:"1"`
+	I64s   []int64           `thrift:"2"`
+	Values map[string]string `thrift:"3"`
+	Keys   map[int64]int32   `thrift:"4"`
+	Int    int64             `thrift:"5"`
+}
+
+func TestDecodeMismatchedElems(t *testing.T) {
+	v2 := &testElemsV2{
+		Strs:   []string{"a"},
+		I64s:   []int64{1 << 40, 2},
+		Values: map[string]string{"k": "v"},
+		Keys:   map[int64]int32{1 << 40: 1},
+		Int:    1 << 40,
+	}
+	data, err := Marshal(v2, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A mismatched element is an error rather than a panic or truncation
+	var de *DecodeError
+	opts := DecodeOptions{CoerceTypes: true, ErrorPaths: true}
+	if err := DecodeStructWithOptions(BinaryProtocol.NewProtocolReader(bytes.NewReader(data)), &testElemsV1{}, opts); !errors.As(err, &de) || de.Path != "testElemsV1.Strs[0] (field 1)" || de.ExpectedType != TypeI32 || de.ActualType != TypeString {
+		t.Fatalf("Expected a DecodeError for Strs[0] instead of %+v", err)
+	}
+
+	// With SkipMismatched it's skipped and integers that don't fit are
+	// left as zero. An int is encoded as an i32 so must fit in one.
+	var mismatched []string
+	opts = DecodeOptions{
+		CoerceTypes:    true,
+		SkipMismatched: true,
+		OnMismatch: func(err *DecodeError) {
+			mismatched = append(mismatched, fmt.Sprintf("%s %s %s", err.Path, typeName(err.ExpectedType), typeName(err.ActualType)))
+		},
+	}
+	v1 := &testElemsV1{}
+	if err := DecodeStructWithOptions(BinaryProtocol.NewProtocolReader(bytes.NewReader(data)), v1, opts); err != nil {
+		t.Fatalf("DecodeStructWithOptions returned error: %+v", err)
+	}
+	expected := &testElemsV1{I64s: []int32{0, 2}, Values: map[string]int32{}, Keys: map[int32]int32{0: 1}}
+	expectedMismatched := []string{
+		"testElemsV1.Strs[0] (field 1) i32 string",
+		"testElemsV1.I64s[0] (field 2) i32 i64",
+		"testElemsV1.Values[value 0] (field 3) i32 string",
+		"testElemsV1.Keys[key 0] (field 4) i32 i64",
+		"testElemsV1.Int (field 5) i32 i64",
+	}
+	if !reflect.DeepEqual(v1, expected) || !reflect.DeepEqual(mismatched, expectedMismatched) {
+		t.Fatalf("Expected %+v and mismatches %q instead of %+v and %q", expected, expectedMismatched, v1, mismatched)
+	}
+}
+
+type testStrictElemsV1 struct {
+	Ints   []int64          `thrift:"1"`
+	Set    map[int64]bool   `thrift:"2,set"`
+	Values map[string]int64 `thrift:"3"`
+}
+
+// testStrictElemsV2 is testStrictElemsV1 with narrower elements
+type testStrictElemsV2 struct {
+	Ints   []int32          `thrift:"1"`
+	Set    map[int32]bool   `thrift:"2,set"`
+	Values map[string]int32 `thrift:"3"`
+}
+
+func TestDecodeStrictElems(t *testing.T) {
+	data, err := Marshal(&testStrictElemsV2{
+		Ints:   []int32{1, -2},
+		Set:    map[int32]bool{3: true},
+		Values: map[string]int32{"k": 4},
+	}, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without CoerceTypes elements aren't checked against the struct so
+	// DecodeStruct reads these as it always has
+	expected := &testStrictElemsV1{
+		Ints:   []int64{1, -2},
+		Set:    map[int64]bool{3: true},
+		Values: map[string]int64{"k": 4},
+	}
+	for _, opts := range []DecodeOptions{{}, {SkipMismatched: true}} {
+		v1 := &testStrictElemsV1{}
+		if err := DecodeStructWithOptions(BinaryProtocol.NewProtocolReader(bytes.NewReader(data)), v1, opts); err != nil {
+			t.Fatalf("DecodeStructWithOptions(%+v) returned error: %+v", opts, err)
+		}
+		if !reflect.DeepEqual(v1, expected) {
+			t.Fatalf("Expected %+v instead of %+v", expected, v1)
+		}
+	}
+}
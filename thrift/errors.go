@@ -0,0 +1,195 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecodeError is returned by DecodeStructWithOptions when decoding fails
+// and DecodeOptions.ErrorPaths is set. It's also passed to
+// DecodeOptions.OnMismatch. It records where in the value the failure
+// happened and wraps the underlying error which can be inspected with
+// errors.As.
+type DecodeError struct {
+	// Path is the location of the failure such as
+	// "Batch.Entries[3].Meta.TTL (field 7)".
+	Path string
+	// ExpectedType and ActualType are set when the type read from the
+	// wire doesn't match the field's type and are TypeStop otherwise.
+	ExpectedType byte
+	ActualType   byte
+	// Offset is the number of bytes read from the input when decoding
+	// failed or -1 if it's not known. It's known when using Deserializer.
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	s := "thrift: decoding " + e.Path + ": " + strings.TrimPrefix(e.Err.Error(), "thrift: ")
+	if e.ExpectedType != TypeStop || e.ActualType != TypeStop {
+		s += fmt.Sprintf(" (expected %s, found %s)", typeName(e.ExpectedType), typeName(e.ActualType))
+	}
+	if e.Offset >= 0 {
+		s += " at offset " + strconv.FormatInt(e.Offset, 10)
+	}
+	return s
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeError is returned by EncodeStructWithOptions when encoding fails
+// and EncodeOptions.ErrorPaths is set. It records where in the value the
+// failure happened and wraps the underlying error which can be inspected
+// with errors.As.
+type EncodeError struct {
+	// Path is the location of the failure such as
+	// "Batch.Entries[3].Meta.TTL (field 7)".
+	Path string
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	return "thrift: encoding " + e.Path + ": " + strings.TrimPrefix(e.Err.Error(), "thrift: ")
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+func typeName(thriftType byte) string {
+	if name, ok := TypeNames[int(thriftType)]; ok {
+		return name
+	}
+	return "type " + strconv.Itoa(int(thriftType))
+}
+
+// setOffset sets the offset of a DecodeError in err if it's not known.
+func setOffset(err error, offset int64) error {
+	var de *DecodeError
+	if errors.As(err, &de) && de.Offset < 0 {
+		de.Offset = offset
+	}
+	return err
+}
+
+const (
+	pathField = iota
+	pathIndex
+	pathMapKey
+	pathMapValue
+)
+
+// pathElem is a step from a value to one inside it. It holds no pointers
+// so tracking the path is cheap; names are looked up from the types when
+// an error needs it.
+type pathElem struct {
+	kind  int8
+	id    int16 // field ID
+	index int   // list, set or map element index
+}
+
+// valuePath is the location of the value being encoded or decoded. The
+// first few steps are kept in an array so tracking them doesn't allocate.
+type valuePath struct {
+	n     int
+	elems [8]pathElem
+	more  []pathElem
+}
+
+func (p *valuePath) push(e pathElem) {
+	if p.n < len(p.elems) {
+		p.elems[p.n] = e
+	} else {
+		p.more = append(p.more[:p.n-len(p.elems)], e)
+	}
+	p.n++
+}
+
+func (p *valuePath) pop() {
+	p.n--
+}
+
+// format returns the path starting from a value of type t.
+func (p *valuePath) format(t reflect.Type) string {
+	elems := p.elems[:]
+	if p.n > len(p.elems) {
+		elems = append(elems, p.more[:p.n-len(p.elems)]...)
+	} else {
+		elems = elems[:p.n]
+	}
+	t = indirectType(t)
+	s := ""
+	if t != nil {
+		s = t.Name()
+	}
+	field, hasField := 0, false
+	for _, e := range elems {
+		switch e.kind {
+		case pathField:
+			field, hasField = int(e.id), true
+			var f encodeField
+			var ok bool
+			if t != nil && t.Kind() == reflect.Struct {
+				m, _ := encodeFields(t)
+				f, ok = m.fields[field]
+			}
+			if !ok {
+				s += ".<unknown>"
+				t = nil
+				continue
+			}
+			s += "." + f.name
+			t = indirectType(t.FieldByIndex(f.index).Type)
+		case pathIndex:
+			s += "[" + strconv.Itoa(e.index) + "]"
+			if t != nil && t.Kind() == reflect.Map {
+				t = indirectType(t.Key())
+			} else if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+				t = indirectType(t.Elem())
+			} else {
+				t = nil
+			}
+		case pathMapKey, pathMapValue:
+			if e.kind == pathMapKey {
+				s += "[key " + strconv.Itoa(e.index) + "]"
+			} else {
+				s += "[value " + strconv.Itoa(e.index) + "]"
+			}
+			if t == nil || t.Kind() != reflect.Map {
+				t = nil
+			} else if e.kind == pathMapKey {
+				t = indirectType(t.Key())
+			} else {
+				t = indirectType(t.Elem())
+			}
+		}
+	}
+	if hasField {
+		s += " (field " + strconv.Itoa(field) + ")"
+	}
+	return s
+}
+
+// valueType returns the type of v or nil if v isn't valid.
+func valueType(v reflect.Value) reflect.Type {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Type()
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
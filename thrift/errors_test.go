@@ -0,0 +1,148 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type testErrorMeta struct {
+	ID  *string `thrift:"1,required"`
+	TTL int32   `thrift:"7"`
+}
+
+type testErrorEntry struct {
+	Meta *testErrorMeta `thrift:"2"`
+}
+
+type testErrorBatch struct {
+	Entries []*testErrorEntry         `thrift:"1"`
+	ByName  map[string]*testErrorMeta `thrift:"2"`
+}
+
+// testErrorMetaWire has the same fields as testErrorMeta but TTL is a string
+type testErrorMetaWire struct {
+	ID  *string `thrift:"1,required"`
+	TTL string  `thrift:"7"`
+}
+
+type testErrorEntryWire struct {
+	Meta *testErrorMetaWire `thrift:"2"`
+}
+
+type testErrorBatchWire struct {
+	Entries []*testErrorEntryWire `thrift:"1"`
+}
+
+func TestDecodeErrorPath(t *testing.T) {
+	id := "id"
+	data, err := Marshal(&testErrorBatchWire{Entries: []*testErrorEntryWire{
+		{Meta: &testErrorMetaWire{ID: &id}},
+		{Meta: &testErrorMetaWire{ID: &id, TTL: "1h"}},
+	}}, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDeserializer(BinaryProtocol)
+	d.Options.ErrorPaths = true
+	err = d.Unmarshal(data, &testErrorBatch{})
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("Expected a DecodeError instead of %+v", err)
+	}
+	if de.Path != "testErrorBatch.Entries[1].Meta.TTL (field 7)" {
+		t.Fatalf("Wrong path %q", de.Path)
+	}
+	if de.ExpectedType != TypeI32 || de.ActualType != TypeString {
+		t.Fatalf("Expected types i32 and string instead of %d and %d", de.ExpectedType, de.ActualType)
+	}
+	if de.Offset <= 0 || de.Offset >= int64(len(data)) {
+		t.Fatalf("Expected an offset within the data instead of %d", de.Offset)
+	}
+	var ue *UnsupportedValueError
+	if !errors.As(err, &ue) {
+		t.Fatalf("Expected the DecodeError to wrap an UnsupportedValueError instead of %+v", de.Err)
+	}
+	expected := "thrift: decoding testErrorBatch.Entries[1].Meta.TTL (field 7): " +
+		"unsupported value (0): type mismatch (expected i32, found string) at offset 37"
+	if msg := err.Error(); msg != expected {
+		t.Fatalf("Unexpected error message %q", msg)
+	}
+
+	// Required fields missing from map values
+	s := NewSerializer(BinaryProtocol)
+	s.Options.ErrorPaths = true
+	_, err = s.Marshal(&testErrorBatch{ByName: map[string]*testErrorMeta{"b": {}}})
+	if err == nil {
+		t.Fatal("Expected an error encoding a missing required field")
+	}
+	var ee *EncodeError
+	var me *MissingRequiredField
+	if !errors.As(err, &ee) || !errors.As(err, &me) {
+		t.Fatalf("Expected an EncodeError wrapping a MissingRequiredField instead of %+v", err)
+	}
+	if ee.Path != "testErrorBatch.ByName[value 0].ID (field 1)" {
+		t.Fatalf("Wrong path %q", ee.Path)
+	}
+	buf := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(buf, true)
+	w.WriteStructBegin("")
+	w.WriteFieldBegin("", TypeMap, 2)
+	w.WriteMapBegin(TypeString, TypeStruct, 1)
+	w.WriteString("b")
+	EncodeStruct(w, &TestEmptyStruct{})
+	w.WriteMapEnd()
+	w.WriteFieldEnd()
+	w.WriteFieldStop()
+	w.WriteStructEnd()
+	err = DecodeStructWithOptions(NewBinaryProtocolReader(buf, false), &testErrorBatch{}, DecodeOptions{ErrorPaths: true})
+	if !errors.As(err, &de) || !errors.As(err, &me) {
+		t.Fatalf("Expected a DecodeError wrapping a MissingRequiredField instead of %+v", err)
+	}
+	if de.Path != "testErrorBatch.ByName[value 0].ID (field 1)" || de.Offset != -1 {
+		t.Fatalf("Wrong path %q or offset %d", de.Path, de.Offset)
+	}
+}
+
+func TestDecodeErrorTruncated(t *testing.T) {
+	data, err := Marshal(&TestStruct2{Str: "test", Binary: []byte{1, 2, 3}}, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without ErrorPaths errors are returned as they are
+	if err := Unmarshal(data[:len(data)-3], &TestStruct2{}, BinaryProtocol); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF instead of %+v", err)
+	}
+
+	d := NewDeserializer(BinaryProtocol)
+	d.Options.ErrorPaths = true
+	err = d.Unmarshal(data[:len(data)-3], &TestStruct2{})
+	var de *DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected a DecodeError wrapping io.ErrUnexpectedEOF instead of %+v", err)
+	}
+	if de.Path != "TestStruct2.Binary (field 2)" || de.Offset != int64(len(data)-3) {
+		t.Fatalf("Wrong path %q or offset %d", de.Path, de.Offset)
+	}
+
+	// Paths deeper than the ones kept without allocating
+	deep := &struct {
+		List [][][][][][][][][]int32 `thrift:"1"`
+	}{[][][][][][][][][]int32{{{{{{{{{1, 2}}}}}}}}}}
+	data, err = Marshal(deep, CompactProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = NewDeserializer(CompactProtocol)
+	d.Options.ErrorPaths = true
+	err = d.Unmarshal(data[:len(data)-2], deep)
+	if !errors.As(err, &de) || de.Path != ".List[0][0][0][0][0][0][0][0][1] (field 1)" {
+		t.Fatalf("Expected a DecodeError with the full path instead of %+v", err)
+	}
+}
@@ -5,10 +5,12 @@
 package thrift
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 )
 
 const (
@@ -30,6 +32,7 @@ type Flusher interface {
 
 type FramedReadWriteCloser struct {
 	wrapped       io.ReadWriteCloser
+	r             *bufio.Reader
 	limitedReader *io.LimitedReader
 	maxFrameSize  int64
 	rtmp          []byte
@@ -42,9 +45,11 @@ func NewFramedReadWriteCloser(wrapped io.ReadWriteCloser, maxFrameSize int) *Fra
 	if maxFrameSize == 0 {
 		maxFrameSize = DefaultMaxFrameSize
 	}
+	r := bufio.NewReader(wrapped)
 	return &FramedReadWriteCloser{
 		wrapped:       wrapped,
-		limitedReader: &io.LimitedReader{R: wrapped, N: 0},
+		r:             r,
+		limitedReader: &io.LimitedReader{R: r, N: 0},
 		maxFrameSize:  int64(maxFrameSize),
 		rtmp:          make([]byte, 4),
 		wtmp:          make([]byte, 4),
@@ -73,7 +78,7 @@ func (f *FramedReadWriteCloser) fillBuffer() error {
 	}
 
 	f.rbuf.Reset()
-	if _, err := io.ReadFull(f.wrapped, f.rtmp); err != nil {
+	if _, err := io.ReadFull(f.r, f.rtmp); err != nil {
 		return err
 	}
 	frameSize := int64(binary.BigEndian.Uint32(f.rtmp))
@@ -91,6 +96,16 @@ func (f *FramedReadWriteCloser) fillBuffer() error {
 	return nil
 }
 
+// waitMessage blocks until the next frame starts to arrive without
+// reading any of it.
+func (f *FramedReadWriteCloser) waitMessage() error {
+	if f.rbuf.Len() > 0 {
+		return nil
+	}
+	_, err := f.r.Peek(1)
+	return err
+}
+
 func (f *FramedReadWriteCloser) Write(p []byte) (int, error) {
 	n, err := f.wbuf.Write(p)
 	if err != nil {
@@ -106,6 +121,16 @@ func (f *FramedReadWriteCloser) Close() error {
 	return f.wrapped.Close()
 }
 
+// SetReadDeadline sets the read deadline of the wrapped connection.
+func (f *FramedReadWriteCloser) SetReadDeadline(t time.Time) error {
+	return setReadDeadline(f.wrapped, t)
+}
+
+// SetWriteDeadline sets the write deadline of the wrapped connection.
+func (f *FramedReadWriteCloser) SetWriteDeadline(t time.Time) error {
+	return setWriteDeadline(f.wrapped, t)
+}
+
 func (f *FramedReadWriteCloser) Flush() error {
 	frameSize := uint32(f.wbuf.Len())
 	if frameSize > 0 {
@@ -0,0 +1,586 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// The header transport is compatible with THeaderTransport as implemented
+// by Apache Thrift and fbthrift. A header frame is laid out as:
+//
+//   length (4) | magic 0x0fff (2) | flags (2) | seqid (4) | header size/4 (2)
+//   protocol id (varint) | num transforms (varint) | transform ids (varint)...
+//   info id (varint) | info data ... | padding
+//   payload
+//
+// Headers are sent as an info block of key/value strings.
+
+// Protocol IDs used in header frames
+const (
+	HeaderProtocolBinary  = 0
+	HeaderProtocolJSON    = 1
+	HeaderProtocolCompact = 2
+)
+
+// Transform IDs used in header frames
+const (
+	HeaderTransformZlib = 1
+)
+
+const (
+	headerMagic        = 0x0fff
+	headerInfoPadding  = 0
+	headerInfoKeyValue = 1
+	maxHeaderSize      = 0xffff * 4
+)
+
+// HeaderClientType is the framing used by the peer of a HeaderTransport.
+type HeaderClientType int
+
+const (
+	HeaderClientHeader HeaderClientType = iota
+	HeaderClientFramed
+	HeaderClientUnframed
+)
+
+// HeaderReadWriter is implemented by transports that carry string headers
+// with every message. The client and server codecs copy the headers to and
+// from each call so these are only safe to use from the goroutine reading
+// or writing messages respectively.
+type HeaderReadWriter interface {
+	// ReadHeaders returns the headers of the last message read.
+	ReadHeaders() map[string]string
+	// WriteHeaders returns the headers to send with the next message
+	// written. They're cleared by every flush, including one that fails
+	// or replies to a peer that doesn't support headers.
+	WriteHeaders() map[string]string
+}
+
+type ErrUnsupportedHeader struct {
+	Kind string
+	ID   uint64
+}
+
+func (e ErrUnsupportedHeader) Error() string {
+	return fmt.Sprintf("thrift: unsupported header %s %d", e.Kind, e.ID)
+}
+
+// HeaderTransport wraps a connection to talk THeader framing. When reading
+// it detects whether the peer sent a header frame, a plain frame or an
+// unframed message (binary or compact) and replies the same way, which
+// lets a server accept all three kinds of clients.
+//
+// Messages may be read and written from different goroutines as net/rpc
+// does.
+type HeaderTransport struct {
+	wrapped      io.ReadWriteCloser
+	maxFrameSize int64
+
+	// Only used by the reader
+	r            *bufio.Reader
+	rbuf         *bytes.Buffer
+	rframe       []byte
+	unframedRead bool
+	readHeaders  map[string]string
+
+	mu           sync.Mutex // protects the following
+	hasRead      bool
+	readFraming  headerFraming // of the last message read
+	readSeqID    uint32
+	writeFraming headerFraming // for the next message written
+
+	// Only used by the writer
+	wbuf         *bytes.Buffer
+	wframe       []byte
+	writing      bool          // a message has been begun but not flushed
+	msgFraming   headerFraming // of the message being written
+	msgSeqID     uint32
+	writeHeaders map[string]string
+}
+
+// headerFraming is how a message is sent.
+type headerFraming struct {
+	clientType HeaderClientType
+	protocol   int
+	transforms []uint64
+}
+
+// NewHeaderTransport returns a transport that sends header frames using the
+// binary protocol until a message is read that says otherwise.
+func NewHeaderTransport(wrapped io.ReadWriteCloser, maxFrameSize int) *HeaderTransport {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &HeaderTransport{
+		wrapped:      wrapped,
+		r:            bufio.NewReader(wrapped),
+		maxFrameSize: int64(maxFrameSize),
+		rbuf:         &bytes.Buffer{},
+		wbuf:         &bytes.Buffer{},
+		rframe:       make([]byte, 0, 64),
+		wframe:       make([]byte, 0, 64),
+		readHeaders:  make(map[string]string),
+		writeHeaders: make(map[string]string),
+	}
+}
+
+func (t *HeaderTransport) ReadHeaders() map[string]string {
+	return t.readHeaders
+}
+
+func (t *HeaderTransport) WriteHeaders() map[string]string {
+	return t.writeHeaders
+}
+
+// ClientType returns the framing of the last message read.
+func (t *HeaderTransport) ClientType() HeaderClientType {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readFraming.clientType
+}
+
+// Protocol returns the protocol ID used for writing messages. Once a
+// message has been read it's the protocol of that message.
+func (t *HeaderTransport) Protocol() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hasRead {
+		return t.readFraming.protocol
+	}
+	return t.writeFraming.protocol
+}
+
+// SetProtocol sets the protocol ID used for writing messages.
+func (t *HeaderTransport) SetProtocol(id int) error {
+	if id != HeaderProtocolBinary && id != HeaderProtocolJSON && id != HeaderProtocolCompact {
+		return ErrUnsupportedHeader{"protocol", uint64(id)}
+	}
+	t.mu.Lock()
+	t.writeFraming.protocol = id
+	t.mu.Unlock()
+	return nil
+}
+
+// AddTransform adds a transform to apply to written header frames.
+func (t *HeaderTransport) AddTransform(id int) error {
+	if id != HeaderTransformZlib {
+		return ErrUnsupportedHeader{"transform", uint64(id)}
+	}
+	t.mu.Lock()
+	tr := t.writeFraming.transforms
+	t.writeFraming.transforms = append(tr[:len(tr):len(tr)], uint64(id))
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *HeaderTransport) Read(p []byte) (int, error) {
+	if err := t.fillBuffer(); err != nil {
+		return 0, err
+	}
+	if t.unframedRead {
+		return t.r.Read(p)
+	}
+	return t.rbuf.Read(p)
+}
+
+func (t *HeaderTransport) ReadByte() (byte, error) {
+	if err := t.fillBuffer(); err != nil {
+		return 0, err
+	}
+	if t.unframedRead {
+		return t.r.ReadByte()
+	}
+	return t.rbuf.ReadByte()
+}
+
+// beginMessage is called at the start of every message so the framing
+// can be detected again for unframed peers.
+func (t *HeaderTransport) beginMessage() error {
+	if t.rbuf.Len() == 0 {
+		t.unframedRead = false
+	}
+	return t.fillBuffer()
+}
+
+// waitMessage blocks until the next message starts to arrive without
+// reading any of it.
+func (t *HeaderTransport) waitMessage() error {
+	if t.rbuf.Len() > 0 {
+		return nil
+	}
+	_, err := t.r.Peek(1)
+	return err
+}
+
+func (t *HeaderTransport) fillBuffer() error {
+	if t.unframedRead || t.rbuf.Len() > 0 {
+		return nil
+	}
+
+	t.rbuf.Reset()
+	b, err := t.r.Peek(4)
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(b)&versionMask == version1 {
+		t.setReadFraming(headerFraming{clientType: HeaderClientUnframed, protocol: HeaderProtocolBinary}, 0)
+		t.unframedRead = true
+		return nil
+	} else if b[0] == compactProtocolID {
+		t.setReadFraming(headerFraming{clientType: HeaderClientUnframed, protocol: HeaderProtocolCompact}, 0)
+		t.unframedRead = true
+		return nil
+	}
+
+	frameSize := int64(binary.BigEndian.Uint32(b))
+	if frameSize > t.maxFrameSize {
+		return ErrFrameTooBig{frameSize, t.maxFrameSize}
+	}
+	if _, err := t.r.Discard(4); err != nil {
+		return err
+	}
+	if int64(cap(t.rframe)) < frameSize {
+		t.rframe = make([]byte, frameSize)
+	}
+	frame := t.rframe[:frameSize]
+	if _, err := io.ReadFull(t.r, frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return err
+	}
+
+	if len(frame) >= 10 && binary.BigEndian.Uint16(frame) == headerMagic {
+		return t.readHeaderFrame(frame)
+	}
+	proto := HeaderProtocolBinary
+	if len(frame) > 0 && frame[0] == compactProtocolID {
+		proto = HeaderProtocolCompact
+	}
+	t.setReadFraming(headerFraming{clientType: HeaderClientFramed, protocol: proto}, 0)
+	t.rbuf.Write(frame)
+	return nil
+}
+
+// setReadFraming records the framing of the message being read. Messages
+// written after it use the same.
+func (t *HeaderTransport) setReadFraming(f headerFraming, seqID uint32) {
+	t.mu.Lock()
+	t.hasRead = true
+	t.readFraming = f
+	t.readSeqID = seqID
+	t.mu.Unlock()
+	for k := range t.readHeaders {
+		delete(t.readHeaders, k)
+	}
+}
+
+func (t *HeaderTransport) readHeaderFrame(frame []byte) error {
+	seqID := binary.BigEndian.Uint32(frame[4:8])
+	headerSize := int(binary.BigEndian.Uint16(frame[8:10])) * 4
+	if 10+headerSize > len(frame) {
+		return ProtocolError{"HeaderTransport", "header size larger than frame"}
+	}
+	hdr := bytes.NewReader(frame[10 : 10+headerSize])
+	payload := frame[10+headerSize:]
+
+	proto, err := binary.ReadUvarint(hdr)
+	if err != nil {
+		return ProtocolError{"HeaderTransport", "invalid protocol id"}
+	}
+	if proto != HeaderProtocolBinary && proto != HeaderProtocolJSON && proto != HeaderProtocolCompact {
+		return ErrUnsupportedHeader{"protocol", proto}
+	}
+	n, err := binary.ReadUvarint(hdr)
+	if err != nil || n > uint64(hdr.Len()) {
+		return ProtocolError{"HeaderTransport", "invalid transform count"}
+	}
+	transforms := make([]uint64, n)
+	for i := range transforms {
+		if transforms[i], err = binary.ReadUvarint(hdr); err != nil {
+			return ProtocolError{"HeaderTransport", "invalid transform id"}
+		}
+		if transforms[i] != HeaderTransformZlib {
+			return ErrUnsupportedHeader{"transform", transforms[i]}
+		}
+	}
+
+	t.setReadFraming(headerFraming{HeaderClientHeader, int(proto), transforms}, seqID)
+
+	for hdr.Len() > 0 {
+		info, err := binary.ReadUvarint(hdr)
+		if err != nil {
+			return ProtocolError{"HeaderTransport", "invalid info id"}
+		}
+		if info != headerInfoKeyValue {
+			// Padding or an unknown info block. Neither can be
+			// skipped so stop processing headers.
+			break
+		}
+		n, err := binary.ReadUvarint(hdr)
+		if err != nil || n > uint64(hdr.Len()) {
+			return ProtocolError{"HeaderTransport", "invalid header count"}
+		}
+		for i := uint64(0); i < n; i++ {
+			k, err := readHeaderString(hdr)
+			if err != nil {
+				return err
+			}
+			v, err := readHeaderString(hdr)
+			if err != nil {
+				return err
+			}
+			t.readHeaders[k] = v
+		}
+	}
+
+	for i := len(transforms) - 1; i >= 0; i-- {
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		payload, err = ioutil.ReadAll(io.LimitReader(zr, t.maxFrameSize+1))
+		zr.Close()
+		if err != nil {
+			return err
+		}
+		if int64(len(payload)) > t.maxFrameSize {
+			return ErrFrameTooBig{int64(len(payload)), t.maxFrameSize}
+		}
+	}
+	t.rbuf.Write(payload)
+	return nil
+}
+
+func readHeaderString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", ProtocolError{"HeaderTransport", "invalid header string"}
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (t *HeaderTransport) Write(p []byte) (int, error) {
+	n, err := t.wbuf.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if ln := int64(t.wbuf.Len()); ln > t.maxFrameSize {
+		return n, ErrFrameTooBig{ln, t.maxFrameSize}
+	}
+	return n, nil
+}
+
+func (t *HeaderTransport) Close() error {
+	return t.wrapped.Close()
+}
+
+// SetReadDeadline sets the read deadline of the wrapped connection.
+func (t *HeaderTransport) SetReadDeadline(d time.Time) error {
+	return setReadDeadline(t.wrapped, d)
+}
+
+// SetWriteDeadline sets the write deadline of the wrapped connection.
+func (t *HeaderTransport) SetWriteDeadline(d time.Time) error {
+	return setWriteDeadline(t.wrapped, d)
+}
+
+// beginWrite starts writing a message with the given sequence ID and
+// returns the protocol to write it with. Messages written after one has
+// been read use its framing so replies match requests.
+func (t *HeaderTransport) beginWrite(seqID uint32) int {
+	t.mu.Lock()
+	if t.hasRead {
+		t.writeFraming = t.readFraming
+	}
+	t.msgFraming = t.writeFraming
+	t.mu.Unlock()
+	t.msgSeqID = seqID
+	t.writing = true
+	return t.msgFraming.protocol
+}
+
+func (t *HeaderTransport) Flush() error {
+	if t.wbuf.Len() == 0 {
+		return nil
+	}
+	defer t.resetWrite()
+
+	if !t.writing {
+		// Written without a HeaderProtocol so reply to the last message
+		t.mu.Lock()
+		seqID := t.readSeqID
+		t.mu.Unlock()
+		t.beginWrite(seqID)
+	}
+	t.writing = false
+	f := t.msgFraming
+
+	switch f.clientType {
+	case HeaderClientUnframed:
+		_, err := t.wrapped.Write(t.wbuf.Bytes())
+		return err
+	case HeaderClientFramed:
+		frame := t.wframe[:4]
+		binary.BigEndian.PutUint32(frame, uint32(t.wbuf.Len()))
+		frame = append(frame, t.wbuf.Bytes()...)
+		t.wframe = frame
+		_, err := t.wrapped.Write(frame)
+		return err
+	}
+
+	payload := t.wbuf.Bytes()
+	for range f.transforms {
+		buf := &bytes.Buffer{}
+		zw := zlib.NewWriter(buf)
+		if _, err := zw.Write(payload); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+	}
+
+	frame := append(t.wframe[:0], 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	frame = appendUvarint(frame, uint64(f.protocol))
+	frame = appendUvarint(frame, uint64(len(f.transforms)))
+	for _, id := range f.transforms {
+		frame = appendUvarint(frame, id)
+	}
+	if len(t.writeHeaders) > 0 {
+		frame = appendUvarint(frame, headerInfoKeyValue)
+		frame = appendUvarint(frame, uint64(len(t.writeHeaders)))
+		for k, v := range t.writeHeaders {
+			frame = appendUvarint(frame, uint64(len(k)))
+			frame = append(frame, k...)
+			frame = appendUvarint(frame, uint64(len(v)))
+			frame = append(frame, v...)
+		}
+	}
+	for (len(frame)-14)%4 != 0 {
+		frame = append(frame, headerInfoPadding)
+	}
+	headerSize := len(frame) - 14
+	if headerSize > maxHeaderSize {
+		return ProtocolError{"HeaderTransport", "headers too large"}
+	}
+	frame = append(frame, payload...)
+	t.wframe = frame
+	if ln := int64(len(frame) - 4); ln > t.maxFrameSize {
+		return ErrFrameTooBig{ln, t.maxFrameSize}
+	}
+
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	binary.BigEndian.PutUint16(frame[4:], headerMagic)
+	binary.BigEndian.PutUint16(frame[6:], 0)
+	binary.BigEndian.PutUint32(frame[8:], t.msgSeqID)
+	binary.BigEndian.PutUint16(frame[12:], uint16(headerSize/4))
+	_, err := t.wrapped.Write(frame)
+	return err
+}
+
+// resetWrite discards the flushed message and its headers whether or not
+// it was written.
+func (t *HeaderTransport) resetWrite() {
+	t.wbuf.Reset()
+	for k := range t.writeHeaders {
+		delete(t.writeHeaders, k)
+	}
+}
+
+// cloneHeaders returns a copy of headers.
+func cloneHeaders(headers map[string]string) map[string]string {
+	m := make(map[string]string, len(headers))
+	for k, v := range headers {
+		m[k] = v
+	}
+	return m
+}
+
+// setHeaders replaces the headers in dst with those in src.
+func setHeaders(dst, src map[string]string) {
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+// HeaderProtocol picks the protocol for each message sent over a
+// HeaderTransport based on its framing. Over any other transport it
+// behaves like BinaryProtocol.
+var HeaderProtocol = NewProtocolBuilder(newHeaderProtocolReader, newHeaderProtocolWriter)
+
+type headerProtocolReader struct {
+	ProtocolReader
+	t       *HeaderTransport
+	readers [3]ProtocolReader
+}
+
+type headerProtocolWriter struct {
+	ProtocolWriter
+	t       *HeaderTransport
+	writers [3]ProtocolWriter
+}
+
+func newHeaderProtocolReader(r io.Reader) ProtocolReader {
+	t, ok := r.(*HeaderTransport)
+	if !ok {
+		return NewBinaryProtocolReader(r, false)
+	}
+	p := &headerProtocolReader{t: t}
+	p.readers[HeaderProtocolBinary] = NewBinaryProtocolReader(t, false)
+	p.readers[HeaderProtocolJSON] = NewJSONProtocolReader(t)
+	p.readers[HeaderProtocolCompact] = NewCompactProtocolReader(t)
+	p.ProtocolReader = p.readers[HeaderProtocolBinary]
+	return p
+}
+
+func newHeaderProtocolWriter(w io.Writer) ProtocolWriter {
+	t, ok := w.(*HeaderTransport)
+	if !ok {
+		return NewBinaryProtocolWriter(w, true)
+	}
+	p := &headerProtocolWriter{t: t}
+	p.writers[HeaderProtocolBinary] = NewBinaryProtocolWriter(t, true)
+	p.writers[HeaderProtocolJSON] = NewJSONProtocolWriter(t)
+	p.writers[HeaderProtocolCompact] = NewCompactProtocolWriter(t)
+	p.ProtocolWriter = p.writers[HeaderProtocolBinary]
+	return p
+}
+
+func (p *headerProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if err = p.t.beginMessage(); err != nil {
+		return
+	}
+	p.ProtocolReader = p.readers[p.t.readFraming.protocol]
+	return p.ProtocolReader.ReadMessageBegin()
+}
+
+func (p *headerProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	p.ProtocolWriter = p.writers[p.t.beginWrite(uint32(seqid))]
+	return p.ProtocolWriter.WriteMessageBegin(name, messageType, seqid)
+}
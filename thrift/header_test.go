@@ -0,0 +1,311 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func writeTestMessage(t *testing.T, tr Transport, seqid int32, value int32) {
+	if err := tr.WriteMessageBegin("test", MessageTypeCall, seqid); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(tr, &TestRequest{value}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestMessage(t *testing.T, tr Transport, seqid int32, value int32) {
+	name, mtype, seq, err := tr.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "test" || mtype != MessageTypeCall || seq != seqid {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seq)
+	}
+	req := &TestRequest{}
+	if err := DecodeStruct(tr, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if req.Value != value {
+		t.Fatalf("Expected value %d instead of %d", value, req.Value)
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+
+	client := NewHeaderTransport(buf, 0)
+	if err := client.SetProtocol(HeaderProtocolCompact); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddTransform(HeaderTransformZlib); err != nil {
+		t.Fatal(err)
+	}
+	ct := NewTransport(client, HeaderProtocol)
+	ct.(HeaderReadWriter).WriteHeaders()["user"] = "sam"
+	writeTestMessage(t, ct, 12, 345)
+	if len(client.WriteHeaders()) != 0 {
+		t.Fatal("Write headers should be cleared after flush")
+	}
+
+	out := buf.Bytes()
+	if binary.BigEndian.Uint32(out) != uint32(len(out)-4) {
+		t.Fatalf("Header frame length %d doesn't match %d", binary.BigEndian.Uint32(out), len(out)-4)
+	}
+	if binary.BigEndian.Uint16(out[4:]) != headerMagic {
+		t.Fatalf("Header frame has the wrong magic %x", out[4:6])
+	}
+	if binary.BigEndian.Uint32(out[8:]) != 12 {
+		t.Fatalf("Header frame has the wrong seqid %d", binary.BigEndian.Uint32(out[8:]))
+	}
+	if hs := int(binary.BigEndian.Uint16(out[12:])) * 4; out[14] != HeaderProtocolCompact || out[15] != 1 || out[16] != HeaderTransformZlib || hs > len(out)-14 {
+		t.Fatalf("Unexpected header %+v", out[14:])
+	}
+
+	server := NewHeaderTransport(buf, 0)
+	st := NewTransport(server, HeaderProtocol)
+	readTestMessage(t, st, 12, 345)
+	if server.ClientType() != HeaderClientHeader {
+		t.Fatalf("Expected a header client instead of %d", server.ClientType())
+	}
+	if server.Protocol() != HeaderProtocolCompact {
+		t.Fatalf("Expected compact protocol instead of %d", server.Protocol())
+	}
+	if v := st.(HeaderReadWriter).ReadHeaders()["user"]; v != "sam" {
+		t.Fatalf("Expected header user=sam instead of '%s'", v)
+	}
+
+	// The reply uses the same protocol and transforms as the request
+	writeTestMessage(t, st, 13, 678)
+	readTestMessage(t, ct, 13, 678)
+	if len(client.ReadHeaders()) != 0 {
+		t.Fatalf("Unexpected headers in reply: %+v", client.ReadHeaders())
+	}
+}
+
+func TestHeaderTransportDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		framed     bool
+		protocol   ProtocolBuilder
+		clientType HeaderClientType
+		protocolID int
+	}{
+		{"unframed binary", false, BinaryProtocol, HeaderClientUnframed, HeaderProtocolBinary},
+		{"unframed compact", false, CompactProtocol, HeaderClientUnframed, HeaderProtocolCompact},
+		{"framed binary", true, BinaryProtocol, HeaderClientFramed, HeaderProtocolBinary},
+		{"framed compact", true, CompactProtocol, HeaderClientFramed, HeaderProtocolCompact},
+	}
+	for _, test := range tests {
+		buf := &ClosingBuffer{&bytes.Buffer{}}
+		ct := NewTransport(buf, test.protocol)
+		if test.framed {
+			ct = NewTransport(NewFramedReadWriteCloser(buf, 0), test.protocol)
+		}
+		writeTestMessage(t, ct, 1, 2)
+		writeTestMessage(t, ct, 3, 4)
+
+		server := NewHeaderTransport(buf, 0)
+		st := NewTransport(server, HeaderProtocol)
+		readTestMessage(t, st, 1, 2)
+		if server.ClientType() != test.clientType || server.Protocol() != test.protocolID {
+			t.Fatalf("%s: detected client type %d and protocol %d", test.name, server.ClientType(), server.Protocol())
+		}
+		readTestMessage(t, st, 3, 4)
+
+		writeTestMessage(t, st, 5, 6)
+		readTestMessage(t, ct, 5, 6)
+		if buf.Len() != 0 {
+			t.Fatalf("%s: %d bytes left over after reading reply", test.name, buf.Len())
+		}
+	}
+}
+
+func TestHeaderTransportFrameTooBig(t *testing.T) {
+	buf := &ClosingBuffer{bytes.NewBuffer([]byte{0, 0, 1, 0, 0x0f, 0xff})}
+	tr := NewHeaderTransport(buf, 16)
+	if _, err := tr.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected ErrFrameTooBig")
+	} else if _, ok := err.(ErrFrameTooBig); !ok {
+		t.Fatalf("Expected ErrFrameTooBig instead of %+v", err)
+	}
+}
+
+func TestHeaderTransportWriteHeadersCleared(t *testing.T) {
+	// Replying to a framed client drops the headers
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	ct := NewTransport(NewFramedReadWriteCloser(buf, 0), BinaryProtocol)
+	h := NewHeaderTransport(buf, 0)
+	st := NewTransport(h, HeaderProtocol)
+	writeTestMessage(t, ct, 1, 2)
+	readTestMessage(t, st, 1, 2)
+	h.WriteHeaders()["a"] = "b"
+	writeTestMessage(t, st, 3, 4)
+	if len(h.WriteHeaders()) != 0 {
+		t.Fatalf("Expected the headers to be cleared instead of %+v", h.WriteHeaders())
+	}
+	readTestMessage(t, ct, 3, 4)
+
+	// So does a failed flush
+	h = NewHeaderTransport(&ClosingBuffer{&bytes.Buffer{}}, 16)
+	h.WriteHeaders()["a"] = "b"
+	if _, err := h.Write(make([]byte, 32)); err == nil {
+		t.Fatal("Expected ErrFrameTooBig")
+	} else if _, ok := err.(ErrFrameTooBig); !ok {
+		t.Fatalf("Expected ErrFrameTooBig instead of %+v", err)
+	}
+	if err := h.Flush(); err == nil {
+		t.Fatal("Expected ErrFrameTooBig")
+	} else if _, ok := err.(ErrFrameTooBig); !ok {
+		t.Fatalf("Expected ErrFrameTooBig instead of %+v", err)
+	}
+	if len(h.WriteHeaders()) != 0 {
+		t.Fatalf("Expected the headers to be cleared instead of %+v", h.WriteHeaders())
+	}
+}
+
+func TestHeaderTransportRPC(t *testing.T) {
+	once.Do(startServer)
+
+	cli, srv := net.Pipe()
+	go rpc.ServeCodec(NewServerCodec(NewTransport(NewHeaderTransport(srv, 0), HeaderProtocol)))
+
+	// A plain framed client should be able to talk to a header server
+	c := NewClient(NewTransport(NewFramedReadWriteCloser(cli, 0), BinaryProtocol), false)
+	defer c.Close()
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if res.Value != 123 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, 123)
+	}
+}
+
+func TestHeaderTransportConcurrentReadWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	ta := NewTransport(NewHeaderTransport(a, 0), HeaderProtocol)
+	tb := NewTransport(NewHeaderTransport(b, 0), HeaderProtocol)
+
+	// Each side writes messages while reading the other side's
+	const n = 100
+	errs := make(chan error, 4)
+	for _, tr := range []Transport{ta, tb} {
+		go func(tr Transport) {
+			for i := int32(0); i < n; i++ {
+				if err := tr.WriteMessageBegin("test", MessageTypeCall, i); err != nil {
+					errs <- err
+					return
+				}
+				if err := EncodeStruct(tr, &TestRequest{i}); err != nil {
+					errs <- err
+					return
+				}
+				if err := tr.WriteMessageEnd(); err != nil {
+					errs <- err
+					return
+				}
+				if err := tr.Flush(); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(tr)
+		go func(tr Transport) {
+			for i := int32(0); i < n; i++ {
+				_, _, seq, err := tr.ReadMessageBegin()
+				if err != nil {
+					errs <- err
+					return
+				}
+				req := &TestRequest{}
+				if err := DecodeStruct(tr, req); err != nil {
+					errs <- err
+					return
+				}
+				if err := tr.ReadMessageEnd(); err != nil {
+					errs <- err
+					return
+				}
+				if seq != i || req.Value != i {
+					errs <- fmt.Errorf("expected message %d instead of %d with value %d", i, seq, req.Value)
+					return
+				}
+			}
+			errs <- nil
+		}(tr)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHeaderTransportCallHeaders(t *testing.T) {
+	svc := &TestBlockingService{started: make(chan struct{}), release: make(chan struct{})}
+	s := rpc.NewServer()
+	s.RegisterName("Thrift", svc)
+
+	// The server echoes the id header and the client adds a trace header
+	cli, srv := net.Pipe()
+	go s.ServeCodec(NewServerCodec(NewTransport(NewHeaderTransport(srv, 0), HeaderProtocol),
+		InterceptorFuncs{BeforeHeadersFunc: func(method string, seqid int32, request interface{}, headers *Headers) error {
+			headers.Write["id"] = headers.Read["id"]
+			headers.Write["trace"] = headers.Read["trace"]
+			return nil
+		}},
+	))
+	c := NewNetRPCClient(NewTransport(NewHeaderTransport(cli, 0), HeaderProtocol), false,
+		InterceptorFuncs{BeforeHeadersFunc: func(method string, seqid int32, request interface{}, headers *Headers) error {
+			headers.Write["trace"] = "t" + strconv.Itoa(int(request.(*TestRequest).Value))
+			return nil
+		}},
+	)
+	defer c.Close()
+
+	// Both requests are in flight before either handler returns
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := strconv.Itoa(i)
+			headers := &Headers{Write: map[string]string{"id": id}}
+			res := &TestResponse{}
+			if err := c.CallWithHeaders("Block", &TestRequest{int32(i)}, res, headers); err != nil {
+				t.Errorf("Client.CallWithHeaders returned error: %+v", err)
+				return
+			}
+			if res.Value != int32(i) || headers.Read["id"] != id || headers.Read["trace"] != "t"+id {
+				t.Errorf("Call %d returned %d with headers %+v", i, res.Value, headers.Read)
+			}
+		}(i)
+	}
+	<-svc.started
+	<-svc.started
+	close(svc.release)
+	wg.Wait()
+}
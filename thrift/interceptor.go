@@ -0,0 +1,115 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import "errors"
+
+// An Interceptor is called around each call made by a client or handled by
+// a server. It's given the method name as sent on the wire.
+//
+// On a server Before is called once the request has been read and before
+// the handler runs. On a client it's called before the request is written.
+// Returning an error fails the call without running the handler or sending
+// the request. A server replies with the error, as is if it's an
+// *ApplicationException and otherwise as an internal error.
+//
+// After is called once the call completes with the response, which is nil
+// if there isn't one, and the call's error. A server passes the
+// *ApplicationException sent to the client as the error.
+//
+// Interceptors are composed in order: Before is called on each in turn and
+// After in the reverse order. After is only called on interceptors whose
+// Before returned nil.
+type Interceptor interface {
+	Before(method string, seqid int32, request interface{}) error
+	After(method string, seqid int32, request, response interface{}, err error)
+}
+
+// Headers are the string headers of a single call over a transport that
+// carries them such as HeaderTransport.
+type Headers struct {
+	// Read are the headers received: the request's on a server and the
+	// response's on a client.
+	Read map[string]string
+	// Write are the headers to send: the response's on a server and the
+	// request's on a client.
+	Write map[string]string
+}
+
+// A HeaderInterceptor is an Interceptor that's also given the headers of
+// each call when the transport carries them. BeforeHeaders is called in
+// place of Before and may add to headers.Write. On a client headers.Read
+// is set to the response's headers before After is called.
+type HeaderInterceptor interface {
+	Interceptor
+	BeforeHeaders(method string, seqid int32, request interface{}, headers *Headers) error
+}
+
+// InterceptorFuncs is an Interceptor calling the functions that are set.
+// BeforeHeadersFunc is called in place of BeforeFunc when it's set and the
+// transport carries headers.
+type InterceptorFuncs struct {
+	BeforeFunc        func(method string, seqid int32, request interface{}) error
+	BeforeHeadersFunc func(method string, seqid int32, request interface{}, headers *Headers) error
+	AfterFunc         func(method string, seqid int32, request, response interface{}, err error)
+}
+
+func (f InterceptorFuncs) Before(method string, seqid int32, request interface{}) error {
+	if f.BeforeFunc == nil {
+		return nil
+	}
+	return f.BeforeFunc(method, seqid, request)
+}
+
+func (f InterceptorFuncs) BeforeHeaders(method string, seqid int32, request interface{}, headers *Headers) error {
+	if f.BeforeHeadersFunc == nil {
+		return f.Before(method, seqid, request)
+	}
+	return f.BeforeHeadersFunc(method, seqid, request, headers)
+}
+
+func (f InterceptorFuncs) After(method string, seqid int32, request, response interface{}, err error) {
+	if f.AfterFunc != nil {
+		f.AfterFunc(method, seqid, request, response, err)
+	}
+}
+
+type interceptorChain []Interceptor
+
+// before calls Before on each interceptor returning the number of them
+// After should be called on. If one fails After is called on the ones
+// before it straight away and zero is returned along with the error.
+// HeaderInterceptors are given headers instead if they're not nil.
+func (ic interceptorChain) before(method string, seqid int32, request interface{}, headers *Headers) (int, error) {
+	for i, in := range ic {
+		var err error
+		if hi, ok := in.(HeaderInterceptor); ok && headers != nil {
+			err = hi.BeforeHeaders(method, seqid, request, headers)
+		} else {
+			err = in.Before(method, seqid, request)
+		}
+		if err != nil {
+			ic[:i].after(method, seqid, request, nil, err)
+			return 0, err
+		}
+	}
+	return len(ic), nil
+}
+
+func (ic interceptorChain) after(method string, seqid int32, request, response interface{}, err error) {
+	for i := len(ic) - 1; i >= 0; i-- {
+		ic[i].After(method, seqid, request, response, err)
+	}
+}
+
+// toApplicationException returns err if it's an *ApplicationException and
+// otherwise wraps it as an internal error.
+func toApplicationException(err error) *ApplicationException {
+	var ex *ApplicationException
+	if errors.As(err, &ex) {
+		return ex
+	}
+	return &ApplicationException{err.Error(), ExceptionInternalError}
+}
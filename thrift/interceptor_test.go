@@ -0,0 +1,182 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordingInterceptor logs the calls made to it
+type recordingInterceptor struct {
+	name   string
+	reject error
+	mu     *sync.Mutex
+	log    *[]string
+}
+
+func (r recordingInterceptor) Before(method string, seqid int32, request interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.log = append(*r.log, fmt.Sprintf("%s.Before %s %+v", r.name, method, request))
+	return r.reject
+}
+
+func (r recordingInterceptor) After(method string, seqid int32, request, response interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.log = append(*r.log, fmt.Sprintf("%s.After %s %+v %+v %v", r.name, method, request, response, err))
+}
+
+type interceptorLog struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (l *interceptorLog) interceptor(name string, reject error) Interceptor {
+	return recordingInterceptor{name: name, reject: reject, mu: &l.mu, log: &l.log}
+}
+
+func (l *interceptorLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	log := l.log
+	l.log = nil
+	return log
+}
+
+func TestServerInterceptors(t *testing.T) {
+	srvLog := &interceptorLog{}
+	var rejected error
+	s := rpc.NewServer()
+	s.RegisterName("Thrift", new(TestService))
+
+	cli, srv := net.Pipe()
+	go s.ServeCodec(NewServerCodec(NewTransport(srv, BinaryProtocol),
+		srvLog.interceptor("a", nil),
+		srvLog.interceptor("b", nil),
+		InterceptorFuncs{BeforeFunc: func(method string, seqid int32, request interface{}) error {
+			return rejected
+		}},
+	))
+	c := NewNetRPCClient(NewTransport(cli, BinaryProtocol), false)
+	defer c.Close()
+
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{1}, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	expected := []string{
+		"a.Before Success &{Value:1}",
+		"b.Before Success &{Value:1}",
+		"b.After Success &{Value:1} &{Value:1} <nil>",
+		"a.After Success &{Value:1} &{Value:1} <nil>",
+	}
+	if log := srvLog.take(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("Expected %q instead of %q", expected, log)
+	}
+
+	c.Call("Fail", &TestRequest{2}, res)
+	expected = []string{
+		"a.Before Fail &{Value:2}",
+		"b.Before Fail &{Value:2}",
+		"b.After Fail &{Value:2} <nil> Internal Error: fail",
+		"a.After Fail &{Value:2} <nil> Internal Error: fail",
+	}
+	if log := srvLog.take(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("Expected %q instead of %q", expected, log)
+	}
+
+	// A rejected request gets the error as a reply without reaching the handler
+	rejected = &ApplicationException{"denied", ExceptionUnknown}
+	var ex *ApplicationException
+	if err := c.Call("Success", &TestRequest{3}, res); !errors.As(err, &ex) || *ex != *rejected.(*ApplicationException) {
+		t.Fatalf("Expected %+v instead of %+v", rejected, err)
+	}
+	expected = []string{
+		"a.Before Success &{Value:3}",
+		"b.Before Success &{Value:3}",
+		"b.After Success &{Value:3} <nil> Unknown Exception: denied",
+		"a.After Success &{Value:3} <nil> Unknown Exception: denied",
+	}
+	if log := srvLog.take(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("Expected %q instead of %q", expected, log)
+	}
+
+	rejected = errors.New("denied")
+	if err := c.Call("Success", &TestRequest{4}, res); !errors.As(err, &ex) || ex.Type != ExceptionInternalError || ex.Message != "denied" {
+		t.Fatalf("Expected an internal error instead of %+v", err)
+	}
+}
+
+func TestClientInterceptors(t *testing.T) {
+	once.Do(startServer)
+
+	cliLog := &interceptorLog{}
+	reject := errors.New("not allowed")
+	c, err := Dial("tcp", serverAddr, true, BinaryProtocol, false,
+		cliLog.interceptor("a", nil),
+		InterceptorFuncs{BeforeFunc: func(method string, seqid int32, request interface{}) error {
+			if request.(*TestRequest).Value < 0 {
+				return reject
+			}
+			return nil
+		}},
+		cliLog.interceptor("b", nil),
+	)
+	if err != nil {
+		t.Fatalf("Dial returned error: %+v", err)
+	}
+	defer c.Close()
+
+	if err := c.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if err := c.Call("Fail", &TestRequest{2}, &TestResponse{}); err == nil {
+		t.Fatal("Client.Call didn't return an error as it should")
+	}
+	if err := c.Call("Success", &TestRequest{-1}, &TestResponse{}); err != reject {
+		t.Fatalf("Expected %+v instead of %+v", reject, err)
+	}
+	expected := []string{
+		"a.Before Success &{Value:1}",
+		"b.Before Success &{Value:1}",
+		"b.After Success &{Value:1} &{Value:1} <nil>",
+		"a.After Success &{Value:1} &{Value:1} <nil>",
+		"a.Before Fail &{Value:2}",
+		"b.Before Fail &{Value:2}",
+		"b.After Fail &{Value:2} <nil> Internal Error: fail",
+		"a.After Fail &{Value:2} <nil> Internal Error: fail",
+		"a.Before Success &{Value:-1}",
+		"a.After Success &{Value:-1} <nil> not allowed",
+	}
+	if log := cliLog.take(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("Expected %q instead of %q", expected, log)
+	}
+
+	cc, err := DialContext(context.Background(), "tcp", serverAddr, true, BinaryProtocol,
+		cliLog.interceptor("a", nil), cliLog.interceptor("b", reject))
+	if err != nil {
+		t.Fatalf("DialContext returned error: %+v", err)
+	}
+	defer cc.Close()
+	if err := cc.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{}); err != reject {
+		t.Fatalf("Expected %+v instead of %+v", reject, err)
+	}
+	expected = []string{
+		"a.Before Success &{Value:1}",
+		"b.Before Success &{Value:1}",
+		"a.After Success &{Value:1} <nil> not allowed",
+	}
+	if log := cliLog.take(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("Expected %q instead of %q", expected, log)
+	}
+}
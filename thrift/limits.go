@@ -0,0 +1,79 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"fmt"
+	"io"
+)
+
+// ProtocolLimits bounds what the binary and compact protocol readers
+// accept from the wire so a malformed or malicious message can't exhaust
+// memory or the stack. Zero means no limit. Exceeding a limit returns a
+// ProtocolError.
+type ProtocolLimits struct {
+	// MaxStringLength is the longest string or binary value in bytes.
+	MaxStringLength int
+	// MaxContainerSize is the most elements in a map, list or set.
+	MaxContainerSize int
+	// MaxDepth is how deeply structs, maps, lists and sets may nest within
+	// a message. The top level struct is at depth 1.
+	MaxDepth int
+}
+
+// BinaryProtocolWithLimits is like BinaryProtocol but its readers enforce
+// limits.
+func BinaryProtocolWithLimits(limits ProtocolLimits) ProtocolBuilder {
+	return NewProtocolBuilder(
+		func(r io.Reader) ProtocolReader { return NewBinaryProtocolReaderWithLimits(r, false, limits) },
+		func(w io.Writer) ProtocolWriter { return NewBinaryProtocolWriter(w, true) },
+	)
+}
+
+// CompactProtocolWithLimits is like CompactProtocol but its readers
+// enforce limits.
+func CompactProtocolWithLimits(limits ProtocolLimits) ProtocolBuilder {
+	return NewProtocolBuilder(
+		func(r io.Reader) ProtocolReader { return NewCompactProtocolReaderWithLimits(r, limits) },
+		NewCompactProtocolWriter,
+	)
+}
+
+// readLimits tracks a reader's progress against its ProtocolLimits.
+type readLimits struct {
+	ProtocolLimits
+	protocol string
+	depth    int
+}
+
+func (l *readLimits) checkLength(n uint64) error {
+	if l.MaxStringLength > 0 && n > uint64(l.MaxStringLength) {
+		return ProtocolError{l.protocol, fmt.Sprintf("string length %d exceeds limit of %d", n, l.MaxStringLength)}
+	}
+	return nil
+}
+
+func (l *readLimits) checkSize(n uint64) error {
+	if l.MaxContainerSize > 0 && n > uint64(l.MaxContainerSize) {
+		return ProtocolError{l.protocol, fmt.Sprintf("container size %d exceeds limit of %d", n, l.MaxContainerSize)}
+	}
+	return nil
+}
+
+// enter is called when a struct or container begins and leave when it
+// ends.
+func (l *readLimits) enter() error {
+	if l.MaxDepth > 0 && l.depth >= l.MaxDepth {
+		return ProtocolError{l.protocol, fmt.Sprintf("nesting depth exceeds limit of %d", l.MaxDepth)}
+	}
+	l.depth++
+	return nil
+}
+
+func (l *readLimits) leave() {
+	if l.depth > 0 {
+		l.depth--
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+// MultiplexedSeparator separates the service name from the method name
+// in multiplexed calls (e.g. "Scribe:Log") the same as Apache Thrift's
+// TMultiplexedProtocol.
+const MultiplexedSeparator = ":"
+
+type multiplexedClient struct {
+	client  RPCClient
+	service string
+}
+
+// NewMultiplexedClient returns a client that calls methods of the named
+// service on a server that hosts several services on one connection. The
+// server codec routes these calls to the receiver registered with
+// rpc.RegisterName under the same service name.
+func NewMultiplexedClient(client RPCClient, serviceName string) RPCClient {
+	return &multiplexedClient{
+		client:  client,
+		service: serviceName,
+	}
+}
+
+func (c *multiplexedClient) Call(method string, request interface{}, response interface{}) error {
+	return c.client.Call(c.service+MultiplexedSeparator+method, request, response)
+}
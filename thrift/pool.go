@@ -0,0 +1,286 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is the error returned by calls made on a closed Pool.
+var ErrPoolClosed = errors.New("thrift.client: pool closed")
+
+const (
+	defaultPoolMinBackoff = 100 * time.Millisecond
+	defaultPoolMaxBackoff = 10 * time.Second
+)
+
+// Pool is an RPCClient that spreads calls over a fixed number of
+// connections to a single address. Connections are dialed when first
+// needed and dropped when a call fails with a connection error, to be
+// redialed by a later call. After a failed dial the connection isn't
+// dialed again until a backoff, doubling on each failure, has passed.
+// Calls in the meantime fail fast with the dial error unless another
+// connection is usable.
+//
+// The exported fields must be set before the first call.
+type Pool struct {
+	// HealthCheck, if set, is called on a connection that has been idle
+	// for HealthCheckInterval before it's used. If it returns an error the
+	// connection is dropped and redialed.
+	HealthCheck         func(RPCClient) error
+	HealthCheckInterval time.Duration
+	// MinBackoff and MaxBackoff bound the delay before redialing after a
+	// failed dial. They default to 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	dial func() (*NetRPCClient, error)
+
+	mu     sync.Mutex // protects the following
+	conns  []*poolConn
+	next   int
+	closed bool
+}
+
+type poolConn struct {
+	mu       sync.Mutex // protects the following
+	client   *NetRPCClient
+	lastUsed time.Time
+	failures int
+	retryAt  time.Time
+	err      error         // last dial error
+	dialing  chan struct{} // closed once the dial or health check in progress is done
+}
+
+// errPoolConnBusy is returned by Pool.connect when another caller is
+// dialing or health checking the connection.
+var errPoolConnBusy = errors.New("thrift.client: pool connection busy")
+
+// NewPool returns a Pool of size connections each opened by calling dial.
+// For example:
+//
+//	pool := thrift.NewPool(4, func() (*thrift.NetRPCClient, error) {
+//		return thrift.DialNetRPCClient("tcp", addr, true, thrift.BinaryProtocol, false)
+//	})
+//	client := &XxxClient{Client: pool}
+func NewPool(size int, dial func() (*NetRPCClient, error)) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		dial:  dial,
+		conns: make([]*poolConn, size),
+	}
+	for i := range p.conns {
+		p.conns[i] = &poolConn{}
+	}
+	return p
+}
+
+// Call invokes the named method on one of the pool's connections.
+func (p *Pool) Call(method string, request interface{}, response interface{}) error {
+	pc, client, err := p.get()
+	if err != nil {
+		return err
+	}
+	err = client.Call(method, request, response)
+	pc.release(client, err)
+	return err
+}
+
+// Close closes all of the pool's connections. Calls in progress fail and
+// later calls return ErrPoolClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.mu.Unlock()
+
+	var err error
+	for _, pc := range conns {
+		pc.mu.Lock()
+		if pc.client != nil {
+			if e := pc.client.Close(); e != nil && err == nil {
+				err = e
+			}
+			pc.client = nil
+		}
+		pc.mu.Unlock()
+	}
+	return err
+}
+
+// get returns a usable connection trying each in turn starting with the
+// next in round-robin order. Connections being dialed by other callers are
+// skipped, and only waited for if none of the others are usable.
+func (p *Pool) get() (*poolConn, *NetRPCClient, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, nil, ErrPoolClosed
+	}
+	start := p.next
+	p.next = (p.next + 1) % len(p.conns)
+	p.mu.Unlock()
+
+	var err error
+	var busy *poolConn
+	for i := 0; i < len(p.conns); i++ {
+		pc := p.conns[(start+i)%len(p.conns)]
+		client, e := p.connect(pc, false)
+		if e == nil {
+			return pc, client, nil
+		}
+		if e == errPoolConnBusy {
+			if busy == nil {
+				busy = pc
+			}
+			continue
+		}
+		err = e
+	}
+	if busy != nil {
+		client, e := p.connect(busy, true)
+		if e == nil {
+			return busy, client, nil
+		}
+		err = e
+	}
+	return nil, nil, err
+}
+
+// connect returns the connection's client, dialing it if needed. The dial
+// and health check are done without pc.mu held so other callers aren't
+// held up by them. If another caller is already doing either, connect
+// waits for it when wait is true and returns errPoolConnBusy otherwise.
+func (p *Pool) connect(pc *poolConn, wait bool) (*NetRPCClient, error) {
+	pc.mu.Lock()
+	for pc.dialing != nil {
+		if !wait {
+			pc.mu.Unlock()
+			return nil, errPoolConnBusy
+		}
+		done := pc.dialing
+		pc.mu.Unlock()
+		<-done
+		pc.mu.Lock()
+	}
+
+	now := time.Now()
+	client := pc.client
+	check := client != nil && p.HealthCheck != nil && p.HealthCheckInterval > 0 && now.Sub(pc.lastUsed) >= p.HealthCheckInterval
+	if client == nil && now.Before(pc.retryAt) {
+		err := pc.err
+		pc.mu.Unlock()
+		return nil, err
+	}
+	if client != nil && !check {
+		pc.lastUsed = now
+		pc.mu.Unlock()
+		return client, nil
+	}
+	done := make(chan struct{})
+	pc.dialing = done
+	pc.mu.Unlock()
+
+	if check {
+		if err := p.HealthCheck(client); err != nil {
+			client.Close()
+			pc.mu.Lock()
+			if pc.client == client {
+				pc.client = nil
+			}
+			pc.mu.Unlock()
+			client = nil
+		}
+	}
+	var err error
+	dialed := client == nil
+	if dialed {
+		client, err = p.dial()
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.dialing = nil
+	close(done)
+	if err != nil {
+		pc.failures++
+		pc.retryAt = now.Add(p.backoff(pc.failures))
+		pc.err = err
+		return nil, err
+	}
+	if dialed {
+		pc.failures = 0
+		pc.err = nil
+		pc.client = client
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		// Closed while dialing
+		client.Close()
+		if pc.client == client {
+			pc.client = nil
+		}
+		return nil, ErrPoolClosed
+	}
+	pc.lastUsed = now
+	return client, nil
+}
+
+func (p *Pool) backoff(failures int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = defaultPoolMinBackoff
+	}
+	if max <= 0 {
+		max = defaultPoolMaxBackoff
+	}
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// release records the use of client and drops it if err shows the
+// connection is broken.
+func (pc *poolConn) release(client *NetRPCClient, err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.client != client {
+		// Already dropped
+		return
+	}
+	if isConnError(err) {
+		client.Close()
+		pc.client = nil
+		return
+	}
+	pc.lastUsed = time.Now()
+}
+
+// isConnError reports whether err means a connection is no longer usable.
+func isConnError(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case rpc.ErrShutdown, io.EOF, io.ErrUnexpectedEOF, ErrClientClosed:
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
@@ -0,0 +1,236 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDialer dials the address it's given and counts attempts
+type testDialer struct {
+	mu    sync.Mutex
+	addr  string
+	err   error
+	dials int
+}
+
+func (d *testDialer) dial() (*NetRPCClient, error) {
+	d.mu.Lock()
+	addr, err := d.addr, d.err
+	d.dials++
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return DialNetRPCClient("tcp", addr, true, BinaryProtocol, false)
+}
+
+func (d *testDialer) set(addr string, err error) {
+	d.mu.Lock()
+	d.addr, d.err = addr, err
+	d.mu.Unlock()
+}
+
+func (d *testDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+func TestPool(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+
+	d := &testDialer{addr: addr}
+	p := NewPool(2, d.dial)
+	defer p.Close()
+
+	for i := int32(0); i < 4; i++ {
+		res := &TestResponse{}
+		if err := p.Call("Success", &TestRequest{i}, res); err != nil {
+			t.Fatalf("Pool.Call returned error: %+v", err)
+		} else if res.Value != i {
+			t.Fatalf("Response value wrong: %d != %d", res.Value, i)
+		}
+	}
+	if n := d.count(); n != 2 {
+		t.Fatalf("Expected 2 dials instead of %d", n)
+	}
+
+	// Application errors don't drop connections
+	if err := p.Call("Fail", &TestRequest{1}, &TestResponse{}); err == nil {
+		t.Fatal("Pool.Call didn't return an error as it should")
+	}
+	if n := d.count(); n != 2 {
+		t.Fatalf("Expected 2 dials instead of %d", n)
+	}
+
+	// Restart the server. Each broken connection fails a call and is
+	// then redialed.
+	s.Shutdown(context.Background())
+	s = NewServer()
+	s.Register(new(TestService))
+	addr, _ = startTestServer(s)
+	d.set(addr, nil)
+	defer s.Shutdown(context.Background())
+
+	failed := 0
+	for i := 0; i < 4; i++ {
+		if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("Expected 2 calls to fail instead of %d", failed)
+	}
+	if n := d.count(); n != 4 {
+		t.Fatalf("Expected 4 dials instead of %d", n)
+	}
+
+	p.Close()
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != ErrPoolClosed {
+		t.Fatalf("Expected ErrPoolClosed instead of %+v", err)
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	dialErr := errors.New("dial failed")
+	d := &testDialer{err: dialErr}
+	p := NewPool(1, d.dial)
+	p.MinBackoff = 50 * time.Millisecond
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != dialErr {
+			t.Fatalf("Expected %+v instead of %+v", dialErr, err)
+		}
+	}
+	if n := d.count(); n != 1 {
+		t.Fatalf("Expected 1 dial during the backoff instead of %d", n)
+	}
+
+	d.set(addr, nil)
+	time.Sleep(p.MinBackoff)
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatalf("Pool.Call returned error: %+v", err)
+	}
+	if n := d.count(); n != 2 {
+		t.Fatalf("Expected 2 dials instead of %d", n)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	d := &testDialer{addr: addr}
+	p := NewPool(1, d.dial)
+	defer p.Close()
+	healthy := true
+	checks := 0
+	p.HealthCheck = func(c RPCClient) error {
+		checks++
+		if !healthy {
+			return errors.New("unhealthy")
+		}
+		return c.Call("Success", &TestRequest{0}, &TestResponse{})
+	}
+	p.HealthCheckInterval = 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+			t.Fatalf("Pool.Call returned error: %+v", err)
+		}
+	}
+	if checks != 0 {
+		t.Fatalf("Expected no health checks on a busy connection instead of %d", checks)
+	}
+
+	time.Sleep(2 * p.HealthCheckInterval)
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatalf("Pool.Call returned error: %+v", err)
+	}
+	if checks != 1 || d.count() != 1 {
+		t.Fatalf("Expected 1 health check and 1 dial instead of %d and %d", checks, d.count())
+	}
+
+	healthy = false
+	time.Sleep(2 * p.HealthCheckInterval)
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatalf("Pool.Call returned error: %+v", err)
+	}
+	if checks != 2 || d.count() != 2 {
+		t.Fatalf("Expected 2 health checks and 2 dials instead of %d and %d", checks, d.count())
+	}
+}
+
+func TestPoolSlowDial(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	// The first dial hangs until released
+	d := &testDialer{addr: addr}
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	var mu sync.Mutex
+	p := NewPool(2, func() (*NetRPCClient, error) {
+		mu.Lock()
+		block := first
+		first = false
+		mu.Unlock()
+		if block {
+			close(dialing)
+			<-release
+		}
+		return d.dial()
+	})
+	defer p.Close()
+
+	slow := make(chan error, 1)
+	go func() {
+		slow <- p.Call("Success", &TestRequest{1}, &TestResponse{})
+	}()
+	<-dialing
+
+	// Both of these use the other connection, including the one whose
+	// turn it is to use the connection being dialed.
+	for i := 0; i < 2; i++ {
+		errc := make(chan error, 1)
+		go func() {
+			errc <- p.Call("Success", &TestRequest{1}, &TestResponse{})
+		}()
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Fatalf("Pool.Call returned error: %+v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Pool.Call blocked behind a slow dial")
+		}
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("Pool.Call returned error: %+v", err)
+	}
+	if n := d.count(); n != 2 {
+		t.Fatalf("Expected 2 dials instead of %d", n)
+	}
+}
@@ -23,6 +23,13 @@ type ProtocolBuilder interface {
 	NewProtocolWriter(io.Writer) ProtocolWriter
 }
 
+// ProtocolReadWriterBuilder is implemented by protocol builders whose
+// reader and writer need to share state such as AutoProtocol. NewTransport
+// uses it in place of the separate reader and writer when available.
+type ProtocolReadWriterBuilder interface {
+	NewProtocolReadWriter(io.Reader, io.Writer) ProtocolReadWriter
+}
+
 type protocolBuilder struct {
 	reader func(io.Reader) ProtocolReader
 	writer func(io.Writer) ProtocolWriter
@@ -0,0 +1,139 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+)
+
+// AutoProtocol detects the protocol and framing used by the peer from the
+// first bytes of a connection. Strict binary, compact and JSON are
+// recognized either unframed or framed, and anything else is read as
+// non-strict binary. Replies use the same protocol and framing as the
+// client. Until the first message is read it writes strict binary.
+//
+// Detection needs the reader and writer to share state, so AutoProtocol
+// should be used through NewTransport. A reader created on its own still
+// detects the protocol, but a writer created on its own always writes
+// strict binary.
+var AutoProtocol ProtocolBuilder = autoProtocolBuilder{}
+
+type autoProtocolBuilder struct{}
+
+func (autoProtocolBuilder) NewProtocolReader(r io.Reader) ProtocolReader {
+	return NewAutoProtocolReadWriter(r, ioutil.Discard)
+}
+
+func (autoProtocolBuilder) NewProtocolWriter(w io.Writer) ProtocolWriter {
+	return NewBinaryProtocolWriter(w, true)
+}
+
+func (autoProtocolBuilder) NewProtocolReadWriter(r io.Reader, w io.Writer) ProtocolReadWriter {
+	return NewAutoProtocolReadWriter(r, w)
+}
+
+type autoProtocol struct {
+	ProtocolReader
+	ProtocolWriter
+	r        *bufio.Reader
+	w        io.Writer
+	framed   *FramedReadWriteCloser
+	detected bool
+}
+
+// NewAutoProtocolReadWriter returns a protocol that detects the protocol
+// and framing used by the peer when the first message is read. The
+// returned value also implements Flusher which must be used to write
+// out framed replies.
+func NewAutoProtocolReadWriter(r io.Reader, w io.Writer) ProtocolReadWriter {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &autoProtocol{
+		ProtocolReader: NewBinaryProtocolReader(br, false),
+		ProtocolWriter: NewBinaryProtocolWriter(w, true),
+		r:              br,
+		w:              w,
+	}
+}
+
+func (p *autoProtocol) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if !p.detected {
+		if err = p.detect(); err != nil {
+			return
+		}
+	}
+	return p.ProtocolReader.ReadMessageBegin()
+}
+
+func (p *autoProtocol) detect() error {
+	b, err := p.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	builder := autoDetectProtocol(b[0])
+	if builder == nil {
+		// Either a framed message or non-strict binary which starts
+		// with the length of the method name. The byte following the
+		// frame size tells them apart.
+		if b, err = p.r.Peek(5); err != nil {
+			return err
+		}
+		if builder = autoDetectProtocol(b[4]); builder != nil {
+			p.framed = NewFramedReadWriteCloser(autoReadWriter{p.r, p.w}, 0)
+		} else {
+			builder = BinaryProtocol
+		}
+	}
+	if p.framed != nil {
+		p.ProtocolReader = builder.NewProtocolReader(p.framed)
+		p.ProtocolWriter = builder.NewProtocolWriter(p.framed)
+	} else {
+		p.ProtocolReader = builder.NewProtocolReader(p.r)
+		p.ProtocolWriter = builder.NewProtocolWriter(p.w)
+	}
+	p.detected = true
+	return nil
+}
+
+// autoDetectProtocol returns the protocol that starts messages with
+// the given byte or nil if there isn't one.
+func autoDetectProtocol(b byte) ProtocolBuilder {
+	switch b {
+	case byte(version1 >> 24):
+		return BinaryProtocol
+	case compactProtocolID:
+		return CompactProtocol
+	case '[':
+		return JSONProtocol
+	}
+	return nil
+}
+
+func (p *autoProtocol) Flush() error {
+	if p.framed != nil {
+		if err := p.framed.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := p.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// autoReadWriter lets a FramedReadWriteCloser wrap the connection. It's
+// never closed through the frames so Close does nothing.
+type autoReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func (autoReadWriter) Close() error {
+	return nil
+}
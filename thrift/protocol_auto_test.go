@@ -0,0 +1,80 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+func TestAutoProtocolRPC(t *testing.T) {
+	once.Do(startServer)
+
+	tests := []struct {
+		name     string
+		framed   bool
+		protocol ProtocolBuilder
+	}{
+		{"unframed binary", false, BinaryProtocol},
+		{"framed binary", true, BinaryProtocol},
+		{"unframed non-strict binary", false, NewProtocolBuilder(
+			func(r io.Reader) ProtocolReader { return NewBinaryProtocolReader(r, false) },
+			func(w io.Writer) ProtocolWriter { return NewBinaryProtocolWriter(w, false) },
+		)},
+		{"unframed compact", false, CompactProtocol},
+		{"framed compact", true, CompactProtocol},
+		{"unframed json", false, JSONProtocol},
+		{"framed json", true, JSONProtocol},
+	}
+	for _, test := range tests {
+		cli, srv := net.Pipe()
+		go rpc.ServeCodec(NewServerCodec(NewTransport(srv, AutoProtocol)))
+
+		var rwc io.ReadWriteCloser = cli
+		if test.framed {
+			rwc = NewFramedReadWriteCloser(cli, 0)
+		}
+		c := NewClient(NewTransport(rwc, test.protocol), false)
+		for i := int32(1); i <= 2; i++ {
+			res := &TestResponse{}
+			if err := c.Call("Success", &TestRequest{i}, res); err != nil {
+				t.Fatalf("%s: Client.Call returned error: %+v", test.name, err)
+			}
+			if res.Value != i {
+				t.Fatalf("%s: Response value wrong: %d != %d", test.name, res.Value, i)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestAutoProtocolReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewCompactProtocolWriter(buf)
+	if err := w.WriteMessageBegin("test", MessageTypeCall, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(w, &TestRequest{8}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := AutoProtocol.NewProtocolReader(buf)
+	name, _, seqid, err := r.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "test" || seqid != 7 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d)", name, seqid)
+	}
+	req := &TestRequest{}
+	if err := DecodeStruct(r, req); err != nil {
+		t.Fatal(err)
+	} else if req.Value != 8 {
+		t.Fatalf("Expected value 8 instead of %d", req.Value)
+	}
+}
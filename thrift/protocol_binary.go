@@ -30,6 +30,7 @@ type binaryProtocolReader struct {
 	r      io.Reader
 	strict bool
 	buf    []byte
+	limits readLimits
 }
 
 var BinaryProtocol = NewProtocolBuilder(
@@ -47,10 +48,17 @@ func NewBinaryProtocolWriter(w io.Writer, strict bool) ProtocolWriter {
 }
 
 func NewBinaryProtocolReader(r io.Reader, strict bool) ProtocolReader {
+	return NewBinaryProtocolReaderWithLimits(r, strict, ProtocolLimits{})
+}
+
+// NewBinaryProtocolReaderWithLimits returns a binary protocol reader that
+// enforces limits.
+func NewBinaryProtocolReaderWithLimits(r io.Reader, strict bool, limits ProtocolLimits) ProtocolReader {
 	p := &binaryProtocolReader{
 		r:      r,
 		strict: strict,
 		buf:    make([]byte, 32),
+		limits: readLimits{ProtocolLimits: limits, protocol: "BinaryProtocol"},
 	}
 	return p
 }
@@ -204,7 +212,11 @@ func (p *binaryProtocolWriter) WriteString(value string) error {
 		_, err := p.w.Write(p.buf[:n])
 		return err
 	}
-	return p.WriteBytes([]byte(value))
+	if err := p.WriteI32(int32(len(value))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, value)
+	return err
 }
 
 func (p *binaryProtocolWriter) WriteBytes(value []byte) error {
@@ -216,6 +228,7 @@ func (p *binaryProtocolWriter) WriteBytes(value []byte) error {
 }
 
 func (p *binaryProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	p.limits.depth = 0
 	size, e := p.ReadI32()
 	if e != nil {
 		err = e
@@ -258,10 +271,11 @@ func (p *binaryProtocolReader) ReadMessageEnd() error {
 }
 
 func (p *binaryProtocolReader) ReadStructBegin() error {
-	return nil
+	return p.limits.enter()
 }
 
 func (p *binaryProtocolReader) ReadStructEnd() error {
+	p.limits.leave()
 	return nil
 }
 
@@ -285,12 +299,16 @@ func (p *binaryProtocolReader) ReadMapBegin() (keyType byte, valueType byte, siz
 		return
 	}
 	var sz int32
-	sz, err = p.ReadI32()
+	if sz, err = p.ReadI32(); err != nil {
+		return
+	}
 	size = int(sz)
+	err = p.readContainerBegin(sz)
 	return
 }
 
 func (p *binaryProtocolReader) ReadMapEnd() error {
+	p.limits.leave()
 	return nil
 }
 
@@ -299,12 +317,16 @@ func (p *binaryProtocolReader) ReadListBegin() (elementType byte, size int, err
 		return
 	}
 	var sz int32
-	sz, err = p.ReadI32()
+	if sz, err = p.ReadI32(); err != nil {
+		return
+	}
 	size = int(sz)
+	err = p.readContainerBegin(sz)
 	return
 }
 
 func (p *binaryProtocolReader) ReadListEnd() error {
+	p.limits.leave()
 	return nil
 }
 
@@ -313,15 +335,28 @@ func (p *binaryProtocolReader) ReadSetBegin() (elementType byte, size int, err e
 		return
 	}
 	var sz int32
-	sz, err = p.ReadI32()
+	if sz, err = p.ReadI32(); err != nil {
+		return
+	}
 	size = int(sz)
+	err = p.readContainerBegin(sz)
 	return
 }
 
 func (p *binaryProtocolReader) ReadSetEnd() error {
+	p.limits.leave()
 	return nil
 }
 
+func (p *binaryProtocolReader) readContainerBegin(size int32) error {
+	if size > 0 {
+		if err := p.limits.checkSize(uint64(size)); err != nil {
+			return err
+		}
+	}
+	return p.limits.enter()
+}
+
 func (p *binaryProtocolReader) ReadBool() (bool, error) {
 	if b, e := p.ReadByte(); e != nil {
 		return false, e
@@ -369,6 +404,9 @@ func (p *binaryProtocolReader) ReadString() (string, error) {
 	if ln < 0 {
 		return "", ProtocolError{"BinaryProtocol", "negative length while reading string"}
 	}
+	if err := p.limits.checkLength(uint64(ln)); err != nil {
+		return "", err
+	}
 	b := p.buf
 	if int(ln) > len(b) {
 		b = make([]byte, ln)
@@ -389,6 +427,9 @@ func (p *binaryProtocolReader) ReadBytes() ([]byte, error) {
 	if ln < 0 {
 		return nil, ProtocolError{"BinaryProtocol", "negative length while reading bytes"}
 	}
+	if err := p.limits.checkLength(uint64(ln)); err != nil {
+		return nil, err
+	}
 	b := make([]byte, ln)
 	if _, err := io.ReadFull(p.r, b); err != nil {
 		return nil, err
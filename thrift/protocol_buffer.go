@@ -0,0 +1,198 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+const (
+	opMessageBegin = iota
+	opMessageEnd
+	opStructBegin
+	opStructEnd
+	opFieldBegin
+	opFieldEnd
+	opFieldStop
+	opMapBegin
+	opMapEnd
+	opListBegin
+	opListEnd
+	opSetBegin
+	opSetEnd
+	opBool
+	opByte
+	opI16
+	opI32
+	opI64
+	opDouble
+	opString
+	opBytes
+)
+
+// protocolOp is a single call recorded by a protocolBuffer.
+type protocolOp struct {
+	op    byte
+	s     string
+	t1    byte
+	t2    byte
+	i     int64
+	f     float64
+	bytes []byte
+}
+
+// protocolBuffer is a ProtocolWriter that records the calls made to it so
+// they can be replayed to another ProtocolWriter later. It lets a value be
+// encoded, and any errors found, before anything is written to a
+// connection without knowing the connection's protocol.
+type protocolBuffer struct {
+	ops []protocolOp
+}
+
+func (b *protocolBuffer) add(op protocolOp) error {
+	b.ops = append(b.ops, op)
+	return nil
+}
+
+// writeTo replays the recorded calls to w.
+func (b *protocolBuffer) writeTo(w ProtocolWriter) error {
+	for _, op := range b.ops {
+		var err error
+		switch op.op {
+		case opMessageBegin:
+			err = w.WriteMessageBegin(op.s, op.t1, int32(op.i))
+		case opMessageEnd:
+			err = w.WriteMessageEnd()
+		case opStructBegin:
+			err = w.WriteStructBegin(op.s)
+		case opStructEnd:
+			err = w.WriteStructEnd()
+		case opFieldBegin:
+			err = w.WriteFieldBegin(op.s, op.t1, int16(op.i))
+		case opFieldEnd:
+			err = w.WriteFieldEnd()
+		case opFieldStop:
+			err = w.WriteFieldStop()
+		case opMapBegin:
+			err = w.WriteMapBegin(op.t1, op.t2, int(op.i))
+		case opMapEnd:
+			err = w.WriteMapEnd()
+		case opListBegin:
+			err = w.WriteListBegin(op.t1, int(op.i))
+		case opListEnd:
+			err = w.WriteListEnd()
+		case opSetBegin:
+			err = w.WriteSetBegin(op.t1, int(op.i))
+		case opSetEnd:
+			err = w.WriteSetEnd()
+		case opBool:
+			err = w.WriteBool(op.i != 0)
+		case opByte:
+			err = w.WriteByte(op.t1)
+		case opI16:
+			err = w.WriteI16(int16(op.i))
+		case opI32:
+			err = w.WriteI32(int32(op.i))
+		case opI64:
+			err = w.WriteI64(op.i)
+		case opDouble:
+			err = w.WriteDouble(op.f)
+		case opString:
+			err = w.WriteString(op.s)
+		case opBytes:
+			err = w.WriteBytes(op.bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *protocolBuffer) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	return b.add(protocolOp{op: opMessageBegin, s: name, t1: messageType, i: int64(seqid)})
+}
+
+func (b *protocolBuffer) WriteMessageEnd() error {
+	return b.add(protocolOp{op: opMessageEnd})
+}
+
+func (b *protocolBuffer) WriteStructBegin(name string) error {
+	return b.add(protocolOp{op: opStructBegin, s: name})
+}
+
+func (b *protocolBuffer) WriteStructEnd() error {
+	return b.add(protocolOp{op: opStructEnd})
+}
+
+func (b *protocolBuffer) WriteFieldBegin(name string, fieldType byte, id int16) error {
+	return b.add(protocolOp{op: opFieldBegin, s: name, t1: fieldType, i: int64(id)})
+}
+
+func (b *protocolBuffer) WriteFieldEnd() error {
+	return b.add(protocolOp{op: opFieldEnd})
+}
+
+func (b *protocolBuffer) WriteFieldStop() error {
+	return b.add(protocolOp{op: opFieldStop})
+}
+
+func (b *protocolBuffer) WriteMapBegin(keyType byte, valueType byte, size int) error {
+	return b.add(protocolOp{op: opMapBegin, t1: keyType, t2: valueType, i: int64(size)})
+}
+
+func (b *protocolBuffer) WriteMapEnd() error {
+	return b.add(protocolOp{op: opMapEnd})
+}
+
+func (b *protocolBuffer) WriteListBegin(elementType byte, size int) error {
+	return b.add(protocolOp{op: opListBegin, t1: elementType, i: int64(size)})
+}
+
+func (b *protocolBuffer) WriteListEnd() error {
+	return b.add(protocolOp{op: opListEnd})
+}
+
+func (b *protocolBuffer) WriteSetBegin(elementType byte, size int) error {
+	return b.add(protocolOp{op: opSetBegin, t1: elementType, i: int64(size)})
+}
+
+func (b *protocolBuffer) WriteSetEnd() error {
+	return b.add(protocolOp{op: opSetEnd})
+}
+
+func (b *protocolBuffer) WriteBool(value bool) error {
+	op := protocolOp{op: opBool}
+	if value {
+		op.i = 1
+	}
+	return b.add(op)
+}
+
+func (b *protocolBuffer) WriteByte(value byte) error {
+	return b.add(protocolOp{op: opByte, t1: value})
+}
+
+func (b *protocolBuffer) WriteI16(value int16) error {
+	return b.add(protocolOp{op: opI16, i: int64(value)})
+}
+
+func (b *protocolBuffer) WriteI32(value int32) error {
+	return b.add(protocolOp{op: opI32, i: int64(value)})
+}
+
+func (b *protocolBuffer) WriteI64(value int64) error {
+	return b.add(protocolOp{op: opI64, i: value})
+}
+
+func (b *protocolBuffer) WriteDouble(value float64) error {
+	return b.add(protocolOp{op: opDouble, f: value})
+}
+
+func (b *protocolBuffer) WriteString(value string) error {
+	return b.add(protocolOp{op: opString, s: value})
+}
+
+// WriteBytes records value without copying it so it mustn't change before
+// the buffer is written.
+func (b *protocolBuffer) WriteBytes(value []byte) error {
+	return b.add(protocolOp{op: opBytes, bytes: value})
+}
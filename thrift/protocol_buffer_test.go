@@ -0,0 +1,54 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProtocolBuffer(t *testing.T) {
+	i := 123
+	s := &TestStruct{
+		String:  "test",
+		Int:     &i,
+		List:    []string{"a"},
+		Map:     map[string]string{"k": "v"},
+		Struct:  &TestStruct2{Str: "s", Binary: []byte{1, 2}},
+		Binary:  []byte{0, 0xff},
+		Set:     []string{"x"},
+		Set2:    map[string]struct{}{"y": {}},
+		Uint32:  1 << 31,
+		Struct2: TestStruct2{Str: "t"},
+	}
+	// Replaying keeps the difference between strings and binary
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		expected := &bytes.Buffer{}
+		w := p.NewProtocolWriter(expected)
+		if err := w.WriteMessageBegin("call", MessageTypeCall, 7); err != nil {
+			t.Fatal(err)
+		}
+		if err := EncodeStruct(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteMessageEnd(); err != nil {
+			t.Fatal(err)
+		}
+
+		b := &protocolBuffer{}
+		b.WriteMessageBegin("call", MessageTypeCall, 7)
+		if err := EncodeStruct(b, s); err != nil {
+			t.Fatal(err)
+		}
+		b.WriteMessageEnd()
+		out := &bytes.Buffer{}
+		if err := b.writeTo(p.NewProtocolWriter(out)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), expected.Bytes()) {
+			t.Fatalf("Replayed as\n%x\ninstead of\n%x", out.Bytes(), expected.Bytes())
+		}
+	}
+}
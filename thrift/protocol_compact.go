@@ -85,6 +85,7 @@ type compactProtocolReader struct {
 	structs     []int16
 	container   []int
 	buf         []byte
+	limits      readLimits
 }
 
 var CompactProtocol = NewProtocolBuilder(NewCompactProtocolReader, NewCompactProtocolWriter)
@@ -102,6 +103,12 @@ func NewCompactProtocolWriter(w io.Writer) ProtocolWriter {
 }
 
 func NewCompactProtocolReader(r io.Reader) ProtocolReader {
+	return NewCompactProtocolReaderWithLimits(r, ProtocolLimits{})
+}
+
+// NewCompactProtocolReaderWithLimits returns a compact protocol reader that
+// enforces limits.
+func NewCompactProtocolReaderWithLimits(r io.Reader, limits ProtocolLimits) ProtocolReader {
 	return &compactProtocolReader{
 		r:           r,
 		lastFieldID: 0,
@@ -110,6 +117,7 @@ func NewCompactProtocolReader(r io.Reader) ProtocolReader {
 		structs:     make([]int16, 0, 8),
 		container:   make([]int, 0, 8),
 		buf:         make([]byte, 64),
+		limits:      readLimits{ProtocolLimits: limits, protocol: "CompactProtocol"},
 	}
 }
 
@@ -274,7 +282,11 @@ func (p *compactProtocolWriter) WriteDouble(value float64) (err error) {
 
 // Write a string to the wire with a varint size preceeding.
 func (p *compactProtocolWriter) WriteString(value string) error {
-	return p.WriteBytes([]byte(value))
+	if err := p.writeUvarint(uint64(len(value))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, value)
+	return err
 }
 
 // Write a byte array, using a varint for the size.
@@ -371,6 +383,7 @@ func (p *compactProtocolReader) readUvarint() (uint64, error) {
 }
 
 func (p *compactProtocolReader) ReadMessageBegin() (string, byte, int32, error) {
+	p.limits.depth = 0
 	protocolID, err := p.ReadByte()
 	if err != nil {
 		return "", 0, -1, err
@@ -401,6 +414,9 @@ func (p *compactProtocolReader) ReadMessageBegin() (string, byte, int32, error)
 // Read a struct begin. There's nothing on the wire for this, but it is our
 // opportunity to push a new struct begin marker onto the field stack.
 func (p *compactProtocolReader) ReadStructBegin() error {
+	if err := p.limits.enter(); err != nil {
+		return err
+	}
 	p.structs = append(p.structs, p.lastFieldID)
 	p.lastFieldID = 0
 	return nil
@@ -412,6 +428,7 @@ func (p *compactProtocolReader) ReadStructEnd() error {
 	// consume the last field we read off the wire
 	p.lastFieldID = p.structs[len(p.structs)-1]
 	p.structs = p.structs[:len(p.structs)-1]
+	p.limits.leave()
 	return nil
 }
 
@@ -463,6 +480,9 @@ func (p *compactProtocolReader) ReadMapBegin() (byte, byte, int, error) {
 	if err != nil {
 		return 0, 0, -1, err
 	}
+	if err := p.readContainerBegin(size); err != nil {
+		return 0, 0, -1, err
+	}
 	keyAndValueType := byte(0)
 	if size > 0 {
 		keyAndValueType, err = p.ReadByte()
@@ -482,15 +502,16 @@ func (p *compactProtocolReader) ReadListBegin() (byte, int, error) {
 	if err != nil {
 		return 0, -1, err
 	}
-	size := int((sizeAndType >> 4) & 0x0f)
+	size := uint64((sizeAndType >> 4) & 0x0f)
 	if size == 15 {
-		s, err := p.readUvarint()
-		if err != nil {
+		if size, err = p.readUvarint(); err != nil {
 			return 0, -1, err
 		}
-		size = int(s)
 	}
-	return compactTypeToThriftType[sizeAndType&0x0f], size, nil
+	if err := p.readContainerBegin(size); err != nil {
+		return 0, -1, err
+	}
+	return compactTypeToThriftType[sizeAndType&0x0f], int(size), nil
 }
 
 // Read a set header off the wire. If the set size is 0-14, the size will
@@ -501,6 +522,13 @@ func (p *compactProtocolReader) ReadSetBegin() (byte, int, error) {
 	return p.ReadListBegin()
 }
 
+func (p *compactProtocolReader) readContainerBegin(size uint64) error {
+	if err := p.limits.checkSize(size); err != nil {
+		return err
+	}
+	return p.limits.enter()
+}
+
 // Read a boolean off the wire. If this is a boolean field, the value should
 // already have been read during readFieldBegin, so we'll just consume the
 // pre-stored value. Otherwise, read a byte.
@@ -551,6 +579,9 @@ func (p *compactProtocolReader) ReadString() (string, error) {
 	} else if ln < 0 {
 		return "", ProtocolError{"CompactProtocol", "negative length in CompactProtocol.ReadString"}
 	}
+	if err := p.limits.checkLength(ln); err != nil {
+		return "", err
+	}
 	b := p.buf
 	if int(ln) > len(b) {
 		b = make([]byte, ln)
@@ -570,6 +601,9 @@ func (p *compactProtocolReader) ReadBytes() ([]byte, error) {
 	} else if ln < 0 {
 		return nil, ProtocolError{"CompactProtocol", "negative length in CompactProtocol.ReadBytes"}
 	}
+	if err := p.limits.checkLength(ln); err != nil {
+		return nil, err
+	}
 	b := make([]byte, ln)
 	if _, err := io.ReadFull(p.r, b); err != nil {
 		return nil, err
@@ -586,13 +620,16 @@ func (p *compactProtocolReader) ReadFieldEnd() error {
 }
 
 func (p *compactProtocolReader) ReadMapEnd() error {
+	p.limits.leave()
 	return nil
 }
 
 func (p *compactProtocolReader) ReadListEnd() error {
+	p.limits.leave()
 	return nil
 }
 
 func (p *compactProtocolReader) ReadSetEnd() error {
+	p.limits.leave()
 	return nil
 }
@@ -599,3 +599,173 @@ func TestBytes(t *testing.T) {
 		}
 	}
 }
+
+var limitedBuilders = []func(ProtocolLimits) ProtocolBuilder{BinaryProtocolWithLimits, CompactProtocolWithLimits}
+
+func isProtocolError(err error) bool {
+	_, ok := err.(ProtocolError)
+	return ok
+}
+
+// String and binary lengths are limited
+func TestLimitsStringLength(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	for _, lb := range limitedBuilders {
+		if err := quick.Check(func(value []byte, max uint8) bool {
+			pb := lb(ProtocolLimits{MaxStringLength: int(max)})
+			exceeds := max > 0 && len(value) > int(max)
+
+			buf.Reset()
+			pw := pb.NewProtocolWriter(buf)
+			pr := pb.NewProtocolReader(buf)
+			if err := pw.WriteString(string(value)); err != nil {
+				t.Error(err)
+				return false
+			}
+			if _, err := pr.ReadString(); exceeds != isProtocolError(err) || (!exceeds && err != nil) {
+				t.Logf("ReadString of %d bytes with limit %d returned %+v", len(value), max, err)
+				return false
+			}
+
+			buf.Reset()
+			if err := pw.WriteBytes(value); err != nil {
+				t.Error(err)
+				return false
+			}
+			if _, err := pr.ReadBytes(); exceeds != isProtocolError(err) || (!exceeds && err != nil) {
+				t.Logf("ReadBytes of %d bytes with limit %d returned %+v", len(value), max, err)
+				return false
+			}
+			return true
+		}, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Container sizes are limited before any elements are read
+func TestLimitsContainerSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	for _, lb := range limitedBuilders {
+		if err := quick.Check(func(size uint16, max uint8) bool {
+			pb := lb(ProtocolLimits{MaxContainerSize: int(max)})
+			exceeds := max > 0 && int(size) > int(max)
+			check := func(name string, err error) bool {
+				if exceeds != isProtocolError(err) || (!exceeds && err != nil) {
+					t.Logf("%s of size %d with limit %d returned %+v", name, size, max, err)
+					return false
+				}
+				return true
+			}
+
+			buf.Reset()
+			pw := pb.NewProtocolWriter(buf)
+			pr := pb.NewProtocolReader(buf)
+			if err := pw.WriteMapBegin(TypeI32, TypeI32, int(size)); err != nil {
+				t.Error(err)
+				return false
+			}
+			if _, _, _, err := pr.ReadMapBegin(); !check("ReadMapBegin", err) {
+				return false
+			}
+
+			buf.Reset()
+			if err := pw.WriteListBegin(TypeI32, int(size)); err != nil {
+				t.Error(err)
+				return false
+			}
+			if _, _, err := pr.ReadListBegin(); !check("ReadListBegin", err) {
+				return false
+			}
+
+			buf.Reset()
+			if err := pw.WriteSetBegin(TypeI32, int(size)); err != nil {
+				t.Error(err)
+				return false
+			}
+			_, _, err := pr.ReadSetBegin()
+			return check("ReadSetBegin", err)
+		}, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// writeNested writes depth levels alternating between structs and lists
+// holding a single struct. depth must be odd.
+func writeNested(w ProtocolWriter, depth int) error {
+	if depth%2 == 0 {
+		if err := w.WriteListBegin(TypeStruct, 1); err != nil {
+			return err
+		}
+		if err := writeNested(w, depth-1); err != nil {
+			return err
+		}
+		return w.WriteListEnd()
+	}
+	if err := w.WriteStructBegin("nested"); err != nil {
+		return err
+	}
+	if depth > 1 {
+		if err := w.WriteFieldBegin("nested", TypeList, 1); err != nil {
+			return err
+		}
+		if err := writeNested(w, depth-1); err != nil {
+			return err
+		}
+		if err := w.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteFieldStop(); err != nil {
+		return err
+	}
+	return w.WriteStructEnd()
+}
+
+// Nesting depth is limited when skipping and reading values
+func TestLimitsDepth(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	for _, lb := range limitedBuilders {
+		if err := quick.Check(func(d, max uint8) bool {
+			depth := int(d%64)*2 + 1
+			pb := lb(ProtocolLimits{MaxDepth: int(max % 128)})
+			exceeds := max%128 > 0 && depth > int(max%128)
+
+			for _, read := range []func(ProtocolReader) error{
+				func(r ProtocolReader) error { return SkipValue(r, TypeStruct) },
+				func(r ProtocolReader) error { _, err := ReadValue(r, TypeStruct); return err },
+			} {
+				buf.Reset()
+				if err := writeNested(pb.NewProtocolWriter(buf), depth); err != nil {
+					t.Error(err)
+					return false
+				}
+				if err := read(pb.NewProtocolReader(buf)); exceeds != isProtocolError(err) || (!exceeds && err != nil) {
+					t.Logf("Reading depth %d with limit %d returned %+v", depth, max%128, err)
+					return false
+				}
+			}
+			return true
+		}, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Limits hold for arbitrary input without panicking
+func TestLimitsRandomInput(t *testing.T) {
+	limits := ProtocolLimits{MaxStringLength: 16, MaxContainerSize: 16, MaxDepth: 4}
+	for _, lb := range limitedBuilders {
+		pb := lb(limits)
+		if err := quick.Check(func(data []byte) bool {
+			SkipValue(pb.NewProtocolReader(bytes.NewReader(data)), TypeStruct)
+			return true
+		}, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,882 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The JSON protocol is wire compatible with Apache Thrift's TJSONProtocol.
+// Messages are encoded as [version,"name",type,seqid,{...}], struct fields
+// as "id":{"type":value}, maps as ["ktype","vtype",size,{...}], and lists
+// and sets as ["etype",size,...]. Binary values are base64 encoded and
+// the special doubles NaN, Infinity and -Infinity are quoted strings.
+
+const (
+	jsonProtocolVersion = 1
+)
+
+const (
+	jsonContextBase = iota
+	jsonContextList
+	jsonContextPair
+)
+
+var jsonTypeNames = map[byte]string{
+	TypeBool:   "tf",
+	TypeByte:   "i8",
+	TypeI16:    "i16",
+	TypeI32:    "i32",
+	TypeI64:    "i64",
+	TypeDouble: "dbl",
+	TypeString: "str",
+	TypeStruct: "rec",
+	TypeMap:    "map",
+	TypeSet:    "set",
+	TypeList:   "lst",
+}
+
+var jsonNameTypes map[string]byte
+
+func init() {
+	jsonNameTypes = make(map[string]byte, len(jsonTypeNames))
+	for t, n := range jsonTypeNames {
+		jsonNameTypes[n] = t
+	}
+}
+
+// jsonContext tracks the separators needed between values of a JSON array
+// (list context) or object (pair context).
+type jsonContext struct {
+	kind  int
+	first bool
+	colon bool
+}
+
+// separator returns the separator that comes before the next value in
+// the context or 0 if there is none.
+func (c *jsonContext) separator() byte {
+	switch c.kind {
+	case jsonContextList:
+		if c.first {
+			c.first = false
+			return 0
+		}
+		return ','
+	case jsonContextPair:
+		if c.first {
+			c.first = false
+			c.colon = true
+			return 0
+		}
+		sep := byte(',')
+		if c.colon {
+			sep = ':'
+		}
+		c.colon = !c.colon
+		return sep
+	}
+	return 0
+}
+
+// escapeNum returns true when the current value is an object key in which
+// case numbers must be quoted.
+func (c *jsonContext) escapeNum() bool {
+	return c.kind == jsonContextPair && c.colon
+}
+
+type jsonContextStack []jsonContext
+
+func newJSONContextStack() jsonContextStack {
+	s := make(jsonContextStack, 1, 8)
+	s[0] = jsonContext{kind: jsonContextBase}
+	return s
+}
+
+func (s *jsonContextStack) push(kind int) {
+	*s = append(*s, jsonContext{kind: kind, first: true})
+}
+
+func (s *jsonContextStack) pop() {
+	if len(*s) > 1 {
+		*s = (*s)[:len(*s)-1]
+	}
+}
+
+func (s jsonContextStack) top() *jsonContext {
+	return &s[len(s)-1]
+}
+
+type jsonProtocolWriter struct {
+	w   io.Writer
+	ctx jsonContextStack
+	buf []byte
+}
+
+type jsonProtocolReader struct {
+	r      io.Reader
+	br     io.ByteReader
+	ctx    jsonContextStack
+	peeked bool
+	next   byte
+	tmp    []byte
+	buf    []byte
+}
+
+var JSONProtocol = NewProtocolBuilder(NewJSONProtocolReader, NewJSONProtocolWriter)
+
+func NewJSONProtocolWriter(w io.Writer) ProtocolWriter {
+	return &jsonProtocolWriter{
+		w:   w,
+		ctx: newJSONContextStack(),
+		buf: make([]byte, 0, 64),
+	}
+}
+
+func NewJSONProtocolReader(r io.Reader) ProtocolReader {
+	p := &jsonProtocolReader{
+		r:   r,
+		ctx: newJSONContextStack(),
+		tmp: make([]byte, 1),
+		buf: make([]byte, 0, 64),
+	}
+	if br, ok := r.(io.ByteReader); ok {
+		p.br = br
+	}
+	return p
+}
+
+func (p *jsonProtocolWriter) write(b []byte) error {
+	_, err := p.w.Write(b)
+	return err
+}
+
+func (p *jsonProtocolWriter) writeByteDirect(b byte) error {
+	p.buf = append(p.buf[:0], b)
+	return p.write(p.buf)
+}
+
+func (p *jsonProtocolWriter) writeSeparator() error {
+	if sep := p.ctx.top().separator(); sep != 0 {
+		return p.writeByteDirect(sep)
+	}
+	return nil
+}
+
+func (p *jsonProtocolWriter) writeObjectStart() error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	p.ctx.push(jsonContextPair)
+	return p.writeByteDirect('{')
+}
+
+func (p *jsonProtocolWriter) writeObjectEnd() error {
+	p.ctx.pop()
+	return p.writeByteDirect('}')
+}
+
+func (p *jsonProtocolWriter) writeArrayStart() error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	p.ctx.push(jsonContextList)
+	return p.writeByteDirect('[')
+}
+
+func (p *jsonProtocolWriter) writeArrayEnd() error {
+	p.ctx.pop()
+	return p.writeByteDirect(']')
+}
+
+func (p *jsonProtocolWriter) writeInteger(value int64) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	quote := p.ctx.top().escapeNum()
+	b := p.buf[:0]
+	if quote {
+		b = append(b, '"')
+	}
+	b = strconv.AppendInt(b, value, 10)
+	if quote {
+		b = append(b, '"')
+	}
+	p.buf = b
+	return p.write(b)
+}
+
+func (p *jsonProtocolWriter) writeTypeName(thriftType byte) error {
+	name, ok := jsonTypeNames[thriftType]
+	if !ok {
+		return ProtocolError{"JSONProtocol", fmt.Sprintf("unsupported type %d", thriftType)}
+	}
+	return p.WriteString(name)
+}
+
+func (p *jsonProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if err := p.writeArrayStart(); err != nil {
+		return err
+	}
+	if err := p.writeInteger(jsonProtocolVersion); err != nil {
+		return err
+	}
+	if err := p.WriteString(name); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(messageType)); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(seqid))
+}
+
+func (p *jsonProtocolWriter) WriteMessageEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteStructBegin(name string) error {
+	return p.writeObjectStart()
+}
+
+func (p *jsonProtocolWriter) WriteStructEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *jsonProtocolWriter) WriteFieldBegin(name string, fieldType byte, id int16) error {
+	if err := p.writeInteger(int64(id)); err != nil {
+		return err
+	}
+	if err := p.writeObjectStart(); err != nil {
+		return err
+	}
+	return p.writeTypeName(fieldType)
+}
+
+func (p *jsonProtocolWriter) WriteFieldEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *jsonProtocolWriter) WriteFieldStop() error {
+	return nil
+}
+
+func (p *jsonProtocolWriter) WriteMapBegin(keyType byte, valueType byte, size int) error {
+	if err := p.writeArrayStart(); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(keyType); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(valueType); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(size)); err != nil {
+		return err
+	}
+	return p.writeObjectStart()
+}
+
+func (p *jsonProtocolWriter) WriteMapEnd() error {
+	if err := p.writeObjectEnd(); err != nil {
+		return err
+	}
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteListBegin(elementType byte, size int) error {
+	if err := p.writeArrayStart(); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(elementType); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(size))
+}
+
+func (p *jsonProtocolWriter) WriteListEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteSetBegin(elementType byte, size int) error {
+	return p.WriteListBegin(elementType, size)
+}
+
+func (p *jsonProtocolWriter) WriteSetEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteBool(value bool) error {
+	if value {
+		return p.writeInteger(1)
+	}
+	return p.writeInteger(0)
+}
+
+// WriteByte writes the value as a signed integer to match the i8 type used
+// by other implementations.
+func (p *jsonProtocolWriter) WriteByte(value byte) error {
+	return p.writeInteger(int64(int8(value)))
+}
+
+func (p *jsonProtocolWriter) WriteI16(value int16) error {
+	return p.writeInteger(int64(value))
+}
+
+func (p *jsonProtocolWriter) WriteI32(value int32) error {
+	return p.writeInteger(int64(value))
+}
+
+func (p *jsonProtocolWriter) WriteI64(value int64) error {
+	return p.writeInteger(value)
+}
+
+func (p *jsonProtocolWriter) WriteDouble(value float64) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	quote := p.ctx.top().escapeNum()
+	b := p.buf[:0]
+	switch {
+	case math.IsNaN(value):
+		b = append(b, `"NaN"`...)
+	case math.IsInf(value, 1):
+		b = append(b, `"Infinity"`...)
+	case math.IsInf(value, -1):
+		b = append(b, `"-Infinity"`...)
+	default:
+		if quote {
+			b = append(b, '"')
+		}
+		b = strconv.AppendFloat(b, value, 'g', -1, 64)
+		if quote {
+			b = append(b, '"')
+		}
+	}
+	p.buf = b
+	return p.write(b)
+}
+
+func (p *jsonProtocolWriter) WriteString(value string) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	p.buf = appendJSONString(p.buf[:0], value)
+	return p.write(p.buf)
+}
+
+func (p *jsonProtocolWriter) WriteBytes(value []byte) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	n := base64.StdEncoding.EncodedLen(len(value)) + 2
+	if cap(p.buf) < n {
+		p.buf = make([]byte, n)
+	}
+	b := p.buf[:n]
+	b[0] = '"'
+	base64.StdEncoding.Encode(b[1:], value)
+	b[n-1] = '"'
+	return p.write(b)
+}
+
+const hexDigits = "0123456789abcdef"
+
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+func (p *jsonProtocolReader) readByte() (byte, error) {
+	if p.peeked {
+		p.peeked = false
+		return p.next, nil
+	}
+	if p.br != nil {
+		return p.br.ReadByte()
+	}
+	if _, err := io.ReadFull(p.r, p.tmp); err != nil {
+		return 0, err
+	}
+	return p.tmp[0], nil
+}
+
+func (p *jsonProtocolReader) peekByte() (byte, error) {
+	if !p.peeked {
+		b, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+		p.next = b
+		p.peeked = true
+	}
+	return p.next, nil
+}
+
+func (p *jsonProtocolReader) skipSpace() error {
+	for {
+		c, err := p.peekByte()
+		if err != nil {
+			return err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return nil
+		}
+		p.peeked = false
+	}
+}
+
+func (p *jsonProtocolReader) expect(c byte) error {
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	b, err := p.readByte()
+	if err != nil {
+		return err
+	}
+	if b != c {
+		return ProtocolError{"JSONProtocol", fmt.Sprintf("expected '%c' but found '%c'", c, b)}
+	}
+	return nil
+}
+
+func (p *jsonProtocolReader) readSeparator() error {
+	if sep := p.ctx.top().separator(); sep != 0 {
+		return p.expect(sep)
+	}
+	return nil
+}
+
+func (p *jsonProtocolReader) readObjectStart() error {
+	if err := p.readSeparator(); err != nil {
+		return err
+	}
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	p.ctx.push(jsonContextPair)
+	return nil
+}
+
+func (p *jsonProtocolReader) readObjectEnd() error {
+	if err := p.expect('}'); err != nil {
+		return err
+	}
+	p.ctx.pop()
+	return nil
+}
+
+func (p *jsonProtocolReader) readArrayStart() error {
+	if err := p.readSeparator(); err != nil {
+		return err
+	}
+	if err := p.expect('['); err != nil {
+		return err
+	}
+	p.ctx.push(jsonContextList)
+	return nil
+}
+
+func (p *jsonProtocolReader) readArrayEnd() error {
+	if err := p.expect(']'); err != nil {
+		return err
+	}
+	p.ctx.pop()
+	return nil
+}
+
+// readNumeric reads the characters that may make up a JSON number. The
+// end of the stream is a valid terminator for a top level number.
+func (p *jsonProtocolReader) readNumeric() ([]byte, error) {
+	b := p.buf[:0]
+	for {
+		c, err := p.peekByte()
+		if err == io.EOF && len(b) > 0 {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if (c < '0' || c > '9') && c != '-' && c != '+' && c != '.' && c != 'e' && c != 'E' {
+			break
+		}
+		p.peeked = false
+		b = append(b, c)
+	}
+	p.buf = b
+	if len(b) == 0 {
+		return nil, ProtocolError{"JSONProtocol", "expected a number"}
+	}
+	return b, nil
+}
+
+func (p *jsonProtocolReader) readInteger() (int64, error) {
+	if err := p.readSeparator(); err != nil {
+		return 0, err
+	}
+	quote := p.ctx.top().escapeNum()
+	if quote {
+		if err := p.expect('"'); err != nil {
+			return 0, err
+		}
+	} else if err := p.skipSpace(); err != nil {
+		return 0, err
+	}
+	b, err := p.readNumeric()
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("invalid integer %q", b)}
+	}
+	if quote {
+		if err := p.expect('"'); err != nil {
+			return 0, err
+		}
+	}
+	return v, nil
+}
+
+func (p *jsonProtocolReader) readIntegerRange(min, max int64) (int64, error) {
+	v, err := p.readInteger()
+	if err != nil {
+		return 0, err
+	}
+	if v < min || v > max {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("integer %d out of range", v)}
+	}
+	return v, nil
+}
+
+func (p *jsonProtocolReader) readSize() (int, error) {
+	v, err := p.readIntegerRange(0, math.MaxInt32)
+	return int(v), err
+}
+
+// readStringBody reads a quoted string, unescaping it into an internal
+// buffer. The returned slice is only valid until the next read.
+func (p *jsonProtocolReader) readStringBody() ([]byte, error) {
+	if err := p.expect('"'); err != nil {
+		return nil, err
+	}
+	b := p.buf[:0]
+	for {
+		c, err := p.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == '"' {
+			break
+		}
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		if c, err = p.readByte(); err != nil {
+			return nil, err
+		}
+		switch c {
+		case '"', '\\', '/':
+			b = append(b, c)
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'u':
+			r, err := p.readHexRune()
+			if err != nil {
+				return nil, err
+			}
+			if utf16.IsSurrogate(r) {
+				if err := p.expect('\\'); err != nil {
+					return nil, err
+				}
+				if err := p.expect('u'); err != nil {
+					return nil, err
+				}
+				r2, err := p.readHexRune()
+				if err != nil {
+					return nil, err
+				}
+				r = utf16.DecodeRune(r, r2)
+			}
+			var enc [utf8.UTFMax]byte
+			n := utf8.EncodeRune(enc[:], r)
+			b = append(b, enc[:n]...)
+		default:
+			return nil, ProtocolError{"JSONProtocol", fmt.Sprintf("invalid escape character '%c'", c)}
+		}
+	}
+	p.buf = b
+	return b, nil
+}
+
+func (p *jsonProtocolReader) readHexRune() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		c, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("invalid hex character '%c'", c)}
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+func (p *jsonProtocolReader) readJSONString() ([]byte, error) {
+	if err := p.readSeparator(); err != nil {
+		return nil, err
+	}
+	return p.readStringBody()
+}
+
+func (p *jsonProtocolReader) readTypeName() (byte, error) {
+	name, err := p.readJSONString()
+	if err != nil {
+		return 0, err
+	}
+	t, ok := jsonNameTypes[string(name)]
+	if !ok {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("unknown type name %q", name)}
+	}
+	return t, nil
+}
+
+func (p *jsonProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if err = p.readArrayStart(); err != nil {
+		return
+	}
+	var v int64
+	if v, err = p.readInteger(); err != nil {
+		return
+	}
+	if v != jsonProtocolVersion {
+		err = ProtocolError{"JSONProtocol", "unsupported version in ReadMessageBegin"}
+		return
+	}
+	if name, err = p.ReadString(); err != nil {
+		return
+	}
+	if v, err = p.readIntegerRange(0, math.MaxUint8); err != nil {
+		return
+	}
+	messageType = byte(v)
+	seqid, err = p.ReadI32()
+	return
+}
+
+func (p *jsonProtocolReader) ReadMessageEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadStructBegin() error {
+	return p.readObjectStart()
+}
+
+func (p *jsonProtocolReader) ReadStructEnd() error {
+	return p.readObjectEnd()
+}
+
+// ReadFieldBegin returns TypeStop at the end of the enclosing object
+// without consuming it. ReadStructEnd consumes the closing brace.
+func (p *jsonProtocolReader) ReadFieldBegin() (fieldType byte, id int16, err error) {
+	if err = p.skipSpace(); err != nil {
+		return
+	}
+	if p.next == '}' {
+		return TypeStop, 0, nil
+	}
+	var v int64
+	if v, err = p.readIntegerRange(math.MinInt16, math.MaxInt16); err != nil {
+		return
+	}
+	id = int16(v)
+	if err = p.readObjectStart(); err != nil {
+		return
+	}
+	fieldType, err = p.readTypeName()
+	return
+}
+
+func (p *jsonProtocolReader) ReadFieldEnd() error {
+	return p.readObjectEnd()
+}
+
+func (p *jsonProtocolReader) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
+	if err = p.readArrayStart(); err != nil {
+		return
+	}
+	if keyType, err = p.readTypeName(); err != nil {
+		return
+	}
+	if valueType, err = p.readTypeName(); err != nil {
+		return
+	}
+	if size, err = p.readSize(); err != nil {
+		return
+	}
+	err = p.readObjectStart()
+	return
+}
+
+func (p *jsonProtocolReader) ReadMapEnd() error {
+	if err := p.readObjectEnd(); err != nil {
+		return err
+	}
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadListBegin() (elementType byte, size int, err error) {
+	if err = p.readArrayStart(); err != nil {
+		return
+	}
+	if elementType, err = p.readTypeName(); err != nil {
+		return
+	}
+	size, err = p.readSize()
+	return
+}
+
+func (p *jsonProtocolReader) ReadListEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadSetBegin() (elementType byte, size int, err error) {
+	return p.ReadListBegin()
+}
+
+func (p *jsonProtocolReader) ReadSetEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadBool() (bool, error) {
+	v, err := p.readInteger()
+	return v != 0, err
+}
+
+func (p *jsonProtocolReader) ReadByte() (byte, error) {
+	v, err := p.readIntegerRange(math.MinInt8, math.MaxUint8)
+	return byte(v), err
+}
+
+func (p *jsonProtocolReader) ReadI16() (int16, error) {
+	v, err := p.readIntegerRange(math.MinInt16, math.MaxInt16)
+	return int16(v), err
+}
+
+func (p *jsonProtocolReader) ReadI32() (int32, error) {
+	v, err := p.readIntegerRange(math.MinInt32, math.MaxInt32)
+	return int32(v), err
+}
+
+func (p *jsonProtocolReader) ReadI64() (int64, error) {
+	return p.readInteger()
+}
+
+func (p *jsonProtocolReader) ReadDouble() (float64, error) {
+	if err := p.readSeparator(); err != nil {
+		return 0, err
+	}
+	if err := p.skipSpace(); err != nil {
+		return 0, err
+	}
+	var b []byte
+	var err error
+	if p.next == '"' {
+		if b, err = p.readStringBody(); err != nil {
+			return 0, err
+		}
+		switch string(b) {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		if !p.ctx.top().escapeNum() {
+			return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("unexpected quoted double %q", b)}
+		}
+	} else if p.ctx.top().escapeNum() {
+		return 0, ProtocolError{"JSONProtocol", "expected a quoted double"}
+	} else if b, err = p.readNumeric(); err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("invalid double %q", b)}
+	}
+	return v, nil
+}
+
+func (p *jsonProtocolReader) ReadString() (string, error) {
+	b, err := p.readJSONString()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ReadBytes decodes a base64 string. Padding is optional since not all
+// implementations write it.
+func (p *jsonProtocolReader) ReadBytes() ([]byte, error) {
+	b, err := p.readJSONString()
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	for len(b) > 0 && b[len(b)-1] == '=' {
+		b = b[:len(b)-1]
+	}
+	out := make([]byte, base64.RawStdEncoding.DecodedLen(len(b)))
+	n, err := base64.RawStdEncoding.Decode(out, b)
+	if err != nil {
+		return nil, ProtocolError{"JSONProtocol", "invalid base64 while reading bytes"}
+	}
+	return out[:n], nil
+}
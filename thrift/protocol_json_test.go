@@ -0,0 +1,122 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONProtocol(t *testing.T) {
+	b := &bytes.Buffer{}
+	testProtocol(t, NewJSONProtocolReader(b), NewJSONProtocolWriter(b))
+}
+
+func TestJSONProtocolMessage(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewJSONProtocolWriter(b)
+	if err := w.WriteMessageBegin("ping", MessageTypeCall, 7); err != nil {
+		t.Fatal(err)
+	}
+	req := &struct {
+		I32    int32            `thrift:"1"`
+		Str    string           `thrift:"2"`
+		Binary []byte           `thrift:"3"`
+		List   []int16          `thrift:"4"`
+		Map    map[int32]string `thrift:"5"`
+		Double float64          `thrift:"6"`
+		Bool   bool             `thrift:"7"`
+	}{5, "a\"b\n", []byte{1, 2, 3, 4}, []int16{1, -2}, map[int32]string{1: "x"}, math.Inf(-1), true}
+	if err := EncodeStruct(w, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[1,"ping",1,7,{"1":{"i32":5},"2":{"str":"a\"b\n"},"3":{"str":"AQIDBA=="},` +
+		`"4":{"lst":["i16",2,1,-2]},"5":{"map":["i32","str",1,{"1":"x"}]},` +
+		`"6":{"dbl":"-Infinity"},"7":{"tf":1}}]`
+	if out := b.String(); out != expected {
+		t.Fatalf("JSONProtocol wrote\n%s\nexpected\n%s", out, expected)
+	}
+
+	r := NewJSONProtocolReader(b)
+	name, mtype, seqid, err := r.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "ping" || mtype != MessageTypeCall || seqid != 7 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seqid)
+	}
+	res := reflect.New(reflect.TypeOf(req).Elem()).Interface()
+	if err := DecodeStruct(r, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req, res) {
+		t.Fatalf("JSONProtocol round trip mismatch: %+v != %+v", req, res)
+	}
+}
+
+func TestJSONProtocolStruct(t *testing.T) {
+	i := 123
+	str := "bar"
+	ts2 := TestStruct2{"qwerty", []byte{1, 2, 3}}
+	s := &TestStruct{
+		"test",
+		&i,
+		[]string{"a", "b"},
+		map[string]string{"a": "b", "1": "2"},
+		&ts2,
+		[]*string{&str},
+		ts2,
+		[]byte{1, 2, 3},
+		[]string{"a", "b"},
+		map[string]struct{}{"i": struct{}{}, "o": struct{}{}},
+		map[string]bool{"q": true},
+		1<<31 + 2,
+		1<<63 + 2,
+		time.Second,
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodeStruct(NewJSONProtocolWriter(buf), s); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &TestStruct{}
+	if err := DecodeStruct(NewJSONProtocolReader(buf), s2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, s2) {
+		t.Fatalf("encdec doesn't match: %+v != %+v", s, s2)
+	}
+}
+
+func TestJSONProtocolSkip(t *testing.T) {
+	buf := bytes.NewBufferString(` {"1": {"str": "not base64!"}, "2": {"map": ["dbl", "lst", 1, {"NaN": ["i8", 1, -1]}]}, "3": {"i32": 9}} `)
+	s := &struct {
+		Value int32 `thrift:"3"`
+	}{}
+	if err := DecodeStruct(NewJSONProtocolReader(buf), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Value != 9 {
+		t.Fatalf("Expected 9 after skipping unknown fields, got %d", s.Value)
+	}
+}
+
+func TestJSONProtocolUnicode(t *testing.T) {
+	r := NewJSONProtocolReader(bytes.NewBufferString(`"\u00e9\ud83d\ude00\/"`))
+	if s, err := r.ReadString(); err != nil {
+		t.Fatal(err)
+	} else if s != "é\U0001F600/" {
+		t.Fatalf("ReadString returned %q", s)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"io"
+	"strconv"
+)
+
+// The simple JSON protocol is a write-only protocol that produces plain JSON
+// similar to Apache Thrift's TSimpleJSONProtocol. It's meant for logging and
+// debugging rather than for exchanging messages since it loses type
+// information.
+//
+// Structs are written as objects keyed by field name (or by field id when
+// no name is given), lists and sets as arrays, and maps as objects. Since
+// JSON object keys must be strings, map keys that are numbers or booleans
+// are written as quoted strings (e.g. {"1":"a"} or {"true":1}) and binary
+// keys are base64 encoded like all binary values. Maps with struct or
+// container keys can't be represented and return a ProtocolError. Messages
+// are written as ["name",type,seqid,{...}].
+
+type simpleJSONProtocolWriter struct {
+	*jsonProtocolWriter
+}
+
+func NewSimpleJSONProtocolWriter(w io.Writer) ProtocolWriter {
+	return &simpleJSONProtocolWriter{
+		jsonProtocolWriter: NewJSONProtocolWriter(w).(*jsonProtocolWriter),
+	}
+}
+
+func (p *simpleJSONProtocolWriter) writeContainerStart(kind int, c byte) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	if p.ctx.top().escapeNum() {
+		return ProtocolError{"SimpleJSONProtocol", "map keys must be strings, numbers or booleans"}
+	}
+	p.ctx.push(kind)
+	return p.writeByteDirect(c)
+}
+
+func (p *simpleJSONProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if err := p.writeContainerStart(jsonContextList, '['); err != nil {
+		return err
+	}
+	if err := p.WriteString(name); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(messageType)); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(seqid))
+}
+
+func (p *simpleJSONProtocolWriter) WriteStructBegin(name string) error {
+	return p.writeContainerStart(jsonContextPair, '{')
+}
+
+func (p *simpleJSONProtocolWriter) WriteFieldBegin(name string, fieldType byte, id int16) error {
+	if name == "" {
+		name = strconv.Itoa(int(id))
+	}
+	return p.WriteString(name)
+}
+
+func (p *simpleJSONProtocolWriter) WriteFieldEnd() error {
+	return nil
+}
+
+func (p *simpleJSONProtocolWriter) WriteMapBegin(keyType byte, valueType byte, size int) error {
+	return p.writeContainerStart(jsonContextPair, '{')
+}
+
+func (p *simpleJSONProtocolWriter) WriteMapEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *simpleJSONProtocolWriter) WriteListBegin(elementType byte, size int) error {
+	return p.writeContainerStart(jsonContextList, '[')
+}
+
+func (p *simpleJSONProtocolWriter) WriteSetBegin(elementType byte, size int) error {
+	return p.writeContainerStart(jsonContextList, '[')
+}
+
+func (p *simpleJSONProtocolWriter) WriteBool(value bool) error {
+	if err := p.writeSeparator(); err != nil {
+		return err
+	}
+	b := p.buf[:0]
+	quote := p.ctx.top().escapeNum()
+	if quote {
+		b = append(b, '"')
+	}
+	b = strconv.AppendBool(b, value)
+	if quote {
+		b = append(b, '"')
+	}
+	p.buf = b
+	return p.write(b)
+}
@@ -0,0 +1,89 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSimpleJSONProtocol(t *testing.T) {
+	s := &struct {
+		Str    string            `thrift:"1"`
+		Bool   bool              `thrift:"2"`
+		List   []int32           `thrift:"3"`
+		Map    map[int64]float64 `thrift:"4"`
+		Set    map[bool]struct{} `thrift:"5"`
+		Binary []byte            `thrift:"6"`
+		Struct *TestStruct2      `thrift:"7"`
+		Double float64           `thrift:"8"`
+	}{
+		Str:    "foo\tbar",
+		Bool:   true,
+		List:   []int32{1, 2, 3},
+		Map:    map[int64]float64{-5: 1.5},
+		Set:    map[bool]struct{}{false: {}},
+		Binary: []byte("hi"),
+		Struct: &TestStruct2{Str: "nested"},
+		Double: math.NaN(),
+	}
+
+	buf := &bytes.Buffer{}
+	w := NewSimpleJSONProtocolWriter(buf)
+	if err := w.WriteMessageBegin("dump", MessageTypeReply, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `["dump",2,3,{"Str":"foo\tbar","Bool":true,"List":[1,2,3],"Map":{"-5":1.5},` +
+		`"Set":[false],"Binary":"aGk=","Struct":{"Str":"nested"},"Double":"NaN"}]`
+	if out := buf.String(); out != expected {
+		t.Fatalf("SimpleJSONProtocol wrote\n%s\nexpected\n%s", out, expected)
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatalf("SimpleJSONProtocol output is not valid JSON: %s", err)
+	}
+}
+
+func TestSimpleJSONProtocolFieldID(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := EncodeStruct(NewSimpleJSONProtocolWriter(buf), &TestRequest{Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); out != `{"Value":7}` {
+		t.Fatalf("SimpleJSONProtocol wrote %s", out)
+	}
+
+	buf.Reset()
+	w := NewSimpleJSONProtocolWriter(buf)
+	w.WriteStructBegin("")
+	w.WriteFieldBegin("", TypeI16, 12)
+	w.WriteI16(-1)
+	w.WriteFieldEnd()
+	w.WriteFieldStop()
+	w.WriteStructEnd()
+	if out := buf.String(); out != `{"12":-1}` {
+		t.Fatalf("SimpleJSONProtocol wrote %s for a field without a name", out)
+	}
+}
+
+func TestSimpleJSONProtocolContainerKey(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewSimpleJSONProtocolWriter(buf)
+	if err := w.WriteMapBegin(TypeList, TypeI32, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteListBegin(TypeI32, 0); err == nil {
+		t.Fatal("SimpleJSONProtocol should not allow container map keys")
+	}
+}
@@ -8,8 +8,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
+// The text protocol writes one call per line (e.g. `FieldBegin("name", 8, 1)`)
+// indented by nesting level. Strings are quoted using Go syntax. The reader
+// ignores indentation and blank lines, accepts unquoted names as older
+// writers produced, including StructBegin() without one, and treats
+// FieldStop() as optional before StructEnd() which makes it convenient for
+// hand written fixtures.
+
 var (
 	ErrUnimplemented = errors.New("thrift: unimplemented")
 )
@@ -19,211 +28,524 @@ type textProtocolWriter struct {
 	indentation string
 }
 
+type textProtocolReader struct {
+	r      io.Reader
+	br     io.ByteReader
+	tmp    []byte
+	line   []byte
+	peeked bool
+	op     string
+	args   []string
+}
+
+var TextProtocol = NewProtocolBuilder(NewTextProtocolReader, NewTextProtocolWriter)
+
 func NewTextProtocolWriter(w io.Writer) ProtocolWriter {
 	return &textProtocolWriter{w: w}
 }
 
+func NewTextProtocolReader(r io.Reader) ProtocolReader {
+	p := &textProtocolReader{
+		r:   r,
+		tmp: make([]byte, 1),
+	}
+	if br, ok := r.(io.ByteReader); ok {
+		p.br = br
+	}
+	return p
+}
+
 func (p *textProtocolWriter) indent() {
 	p.indentation += "\t"
 }
 
 func (p *textProtocolWriter) unindent() {
-	p.indentation = p.indentation[:len(p.indentation)-1]
+	if len(p.indentation) > 0 {
+		p.indentation = p.indentation[:len(p.indentation)-1]
+	}
+}
+
+func (p *textProtocolWriter) write(format string, a ...interface{}) error {
+	if _, err := io.WriteString(p.w, p.indentation); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(p.w, format, a...)
+	return err
 }
 
 func (p *textProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
-	fmt.Fprintf(p.w, "%sMessageBegin(%s, %d, %.8x)\n", p.indentation, name, messageType, seqid)
+	err := p.write("MessageBegin(%q, %d, %.8x)\n", name, messageType, seqid)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteMessageEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sMessageEnd()\n", p.indentation)
-	return nil
+	return p.write("MessageEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteStructBegin(name string) error {
-	fmt.Fprintf(p.w, "%sStructBegin(%s)\n", p.indentation, name)
+	err := p.write("StructBegin(%q)\n", name)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteStructEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sStructEnd()\n", p.indentation)
-	return nil
+	return p.write("StructEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteFieldBegin(name string, fieldType byte, id int16) error {
-	fmt.Fprintf(p.w, "%sFieldBegin(%s, %d, %d)\n", p.indentation, name, fieldType, id)
+	err := p.write("FieldBegin(%q, %d, %d)\n", name, fieldType, id)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteFieldEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sFieldEnd()\n", p.indentation)
-	return nil
+	return p.write("FieldEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteFieldStop() error {
-	fmt.Fprintf(p.w, "%sFieldStop()\n", p.indentation)
-	return nil
+	return p.write("FieldStop()\n")
 }
 
 func (p *textProtocolWriter) WriteMapBegin(keyType byte, valueType byte, size int) error {
-	fmt.Fprintf(p.w, "%sMapBegin(%d, %d, %d)\n", p.indentation, keyType, valueType, size)
+	err := p.write("MapBegin(%d, %d, %d)\n", keyType, valueType, size)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteMapEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sMapEnd()\n", p.indentation)
-	return nil
+	return p.write("MapEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteListBegin(elementType byte, size int) error {
-	fmt.Fprintf(p.w, "%sListBegin(%d, %d)\n", p.indentation, elementType, size)
+	err := p.write("ListBegin(%d, %d)\n", elementType, size)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteListEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sListEnd()\n", p.indentation)
-	return nil
+	return p.write("ListEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteSetBegin(elementType byte, size int) error {
-	fmt.Fprintf(p.w, "%sSetBegin(%d, %d)\n", p.indentation, elementType, size)
+	err := p.write("SetBegin(%d, %d)\n", elementType, size)
 	p.indent()
-	return nil
+	return err
 }
 
 func (p *textProtocolWriter) WriteSetEnd() error {
 	p.unindent()
-	fmt.Fprintf(p.w, "%sSetEnd()\n", p.indentation)
-	return nil
+	return p.write("SetEnd()\n")
 }
 
 func (p *textProtocolWriter) WriteBool(value bool) error {
-	fmt.Fprintf(p.w, "%sBool(%+v)\n", p.indentation, value)
-	return nil
+	return p.write("Bool(%+v)\n", value)
 }
 
 func (p *textProtocolWriter) WriteByte(value byte) error {
-	fmt.Fprintf(p.w, "%sByte(%d)\n", p.indentation, value)
-	return nil
+	return p.write("Byte(%d)\n", value)
 }
 
 func (p *textProtocolWriter) WriteI16(value int16) error {
-	fmt.Fprintf(p.w, "%sI16(%d)\n", p.indentation, value)
-	return nil
+	return p.write("I16(%d)\n", value)
 }
 
 func (p *textProtocolWriter) WriteI32(value int32) error {
-	fmt.Fprintf(p.w, "%sI32(%d)\n", p.indentation, value)
-	return nil
+	return p.write("I32(%d)\n", value)
 }
 
 func (p *textProtocolWriter) WriteI64(value int64) error {
-	fmt.Fprintf(p.w, "%sI64(%d)\n", p.indentation, value)
-	return nil
+	return p.write("I64(%d)\n", value)
 }
 
 func (p *textProtocolWriter) WriteDouble(value float64) error {
-	fmt.Fprintf(p.w, "%sDouble(%f)\n", p.indentation, value)
-	return nil
+	return p.write("Double(%s)\n", strconv.FormatFloat(value, 'g', -1, 64))
 }
 
 func (p *textProtocolWriter) WriteString(value string) error {
-	fmt.Fprintf(p.w, "%sString(%s)\n", p.indentation, value)
-	return nil
+	return p.write("String(%q)\n", value)
 }
 
 func (p *textProtocolWriter) WriteBytes(value []byte) error {
-	fmt.Fprintf(p.w, "%sBytes(%+v)\n", p.indentation, value)
-	return nil
-}
-
-func (p *textProtocolWriter) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
-	return "", 0, 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadMessageEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadStructBegin() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadStructEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadFieldBegin() (fieldType byte, id int16, err error) {
-	return 0, 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadFieldEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
-	return 0, 0, 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadMapEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadListBegin() (elementType byte, size int, err error) {
-	return 0, 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadListEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadSetBegin() (elementType byte, size int, err error) {
-	return 0, 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadSetEnd() error {
-	return ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadBool() (bool, error) {
-	return false, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadByte() (byte, error) {
-	return 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadI16() (int16, error) {
-	return 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadI32() (int32, error) {
-	return 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadI64() (int64, error) {
-	return 0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadDouble() (float64, error) {
-	return 0.0, ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadString() (string, error) {
-	return "", ErrUnimplemented
-}
-
-func (p *textProtocolWriter) ReadBytes() ([]byte, error) {
-	return nil, ErrUnimplemented
+	return p.write("Bytes(%+v)\n", value)
+}
+
+func (p *textProtocolReader) readByte() (byte, error) {
+	if p.br != nil {
+		return p.br.ReadByte()
+	}
+	if _, err := io.ReadFull(p.r, p.tmp); err != nil {
+		return 0, err
+	}
+	return p.tmp[0], nil
+}
+
+// next parses the next non-blank line into an operation and its arguments
+// without consuming it.
+func (p *textProtocolReader) next() (string, error) {
+	if p.peeked {
+		return p.op, nil
+	}
+	var line string
+	for line == "" {
+		p.line = p.line[:0]
+		for {
+			c, err := p.readByte()
+			if err == io.EOF && len(p.line) > 0 {
+				break
+			} else if err != nil {
+				return "", err
+			}
+			if c == '\n' {
+				break
+			}
+			p.line = append(p.line, c)
+		}
+		line = strings.TrimSpace(string(p.line))
+	}
+
+	i := strings.IndexByte(line, '(')
+	if i <= 0 || line[len(line)-1] != ')' {
+		return "", ProtocolError{"TextProtocol", fmt.Sprintf("invalid line %q", line)}
+	}
+	args, err := parseTextArgs(line[i+1 : len(line)-1])
+	if err != nil {
+		return "", err
+	}
+	p.op = line[:i]
+	p.args = args
+	p.peeked = true
+	return p.op, nil
+}
+
+// parseTextArgs splits a comma separated argument list. Arguments may be
+// Go quoted strings which can themselves contain commas.
+func parseTextArgs(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return args, nil
+		}
+		var arg string
+		if s[0] == '"' {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, ProtocolError{"TextProtocol", fmt.Sprintf("invalid quoted string in %q", s)}
+			}
+			if arg, err = strconv.Unquote(q); err != nil {
+				return nil, ProtocolError{"TextProtocol", fmt.Sprintf("invalid quoted string %s", q)}
+			}
+			s = strings.TrimSpace(s[len(q):])
+		} else if i := strings.IndexByte(s, ','); i >= 0 {
+			arg = strings.TrimSpace(s[:i])
+			s = s[i:]
+		} else {
+			arg = s
+			s = ""
+		}
+		args = append(args, arg)
+		if s != "" {
+			if s[0] != ',' {
+				return nil, ProtocolError{"TextProtocol", fmt.Sprintf("expected ',' in %q", s)}
+			}
+			s = s[1:]
+		}
+	}
+}
+
+// read consumes the next line which must be the given operation with
+// nargs arguments.
+func (p *textProtocolReader) read(op string, nargs int) ([]string, error) {
+	next, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if next != op {
+		return nil, ProtocolError{"TextProtocol", fmt.Sprintf("expected %s but found %s", op, next)}
+	}
+	if len(p.args) != nargs {
+		return nil, ProtocolError{"TextProtocol", fmt.Sprintf("%s expects %d arguments but found %d", op, nargs, len(p.args))}
+	}
+	p.peeked = false
+	return p.args, nil
+}
+
+// readName is like read for an operation whose only argument is an
+// optional name. Older writers left out empty names, e.g. StructBegin().
+func (p *textProtocolReader) readName(op string) (string, error) {
+	if next, err := p.next(); err != nil {
+		return "", err
+	} else if next == op && len(p.args) == 0 {
+		p.peeked = false
+		return "", nil
+	}
+	args, err := p.read(op, 1)
+	if err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
+
+func (p *textProtocolReader) readInt(op string, bitSize int) (int64, error) {
+	args, err := p.read(op, 1)
+	if err != nil {
+		return 0, err
+	}
+	return parseTextInt(args[0], 10, bitSize)
+}
+
+func parseTextInt(s string, base int, bitSize int) (int64, error) {
+	v, err := strconv.ParseInt(s, base, bitSize)
+	if err != nil {
+		return 0, ProtocolError{"TextProtocol", fmt.Sprintf("invalid integer %q", s)}
+	}
+	return v, nil
+}
+
+func parseTextType(s string) (byte, error) {
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, ProtocolError{"TextProtocol", fmt.Sprintf("invalid type %q", s)}
+	}
+	return byte(v), nil
+}
+
+func parseTextSize(s string) (int, error) {
+	v, err := parseTextInt(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, ProtocolError{"TextProtocol", "negative size"}
+	}
+	return int(v), nil
+}
+
+func parseTextBytes(s string) ([]byte, error) {
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, ProtocolError{"TextProtocol", fmt.Sprintf("invalid bytes %q", s)}
+	}
+	fields := strings.Fields(s[1 : len(s)-1])
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	b := make([]byte, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, ProtocolError{"TextProtocol", fmt.Sprintf("invalid byte %q", f)}
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+func (p *textProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	var args []string
+	if args, err = p.read("MessageBegin", 3); err != nil {
+		return
+	}
+	name = args[0]
+	if messageType, err = parseTextType(args[1]); err != nil {
+		return
+	}
+	var v int64
+	v, err = parseTextInt(args[2], 16, 32)
+	seqid = int32(v)
+	return
+}
+
+func (p *textProtocolReader) ReadMessageEnd() error {
+	_, err := p.read("MessageEnd", 0)
+	return err
+}
+
+func (p *textProtocolReader) ReadStructBegin() error {
+	_, err := p.readName("StructBegin")
+	return err
+}
+
+func (p *textProtocolReader) ReadStructEnd() error {
+	if op, err := p.next(); err != nil {
+		return err
+	} else if op == "FieldStop" {
+		p.peeked = false
+	}
+	_, err := p.read("StructEnd", 0)
+	return err
+}
+
+// ReadFieldBegin returns TypeStop for FieldStop() as well as for a StructEnd()
+// which it leaves for ReadStructEnd to consume.
+func (p *textProtocolReader) ReadFieldBegin() (fieldType byte, id int16, err error) {
+	var op string
+	if op, err = p.next(); err != nil {
+		return
+	}
+	switch op {
+	case "FieldStop":
+		p.peeked = false
+		return TypeStop, 0, nil
+	case "StructEnd":
+		return TypeStop, 0, nil
+	}
+	var args []string
+	if args, err = p.read("FieldBegin", 3); err != nil {
+		return
+	}
+	if fieldType, err = parseTextType(args[1]); err != nil {
+		return
+	}
+	var v int64
+	v, err = parseTextInt(args[2], 10, 16)
+	id = int16(v)
+	return
+}
+
+func (p *textProtocolReader) ReadFieldEnd() error {
+	_, err := p.read("FieldEnd", 0)
+	return err
+}
+
+func (p *textProtocolReader) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
+	var args []string
+	if args, err = p.read("MapBegin", 3); err != nil {
+		return
+	}
+	if keyType, err = parseTextType(args[0]); err != nil {
+		return
+	}
+	if valueType, err = parseTextType(args[1]); err != nil {
+		return
+	}
+	size, err = parseTextSize(args[2])
+	return
+}
+
+func (p *textProtocolReader) ReadMapEnd() error {
+	_, err := p.read("MapEnd", 0)
+	return err
+}
+
+func (p *textProtocolReader) readCollectionBegin(op string) (elementType byte, size int, err error) {
+	var args []string
+	if args, err = p.read(op, 2); err != nil {
+		return
+	}
+	if elementType, err = parseTextType(args[0]); err != nil {
+		return
+	}
+	size, err = parseTextSize(args[1])
+	return
+}
+
+func (p *textProtocolReader) ReadListBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin("ListBegin")
+}
+
+func (p *textProtocolReader) ReadListEnd() error {
+	_, err := p.read("ListEnd", 0)
+	return err
+}
+
+func (p *textProtocolReader) ReadSetBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin("SetBegin")
+}
+
+func (p *textProtocolReader) ReadSetEnd() error {
+	_, err := p.read("SetEnd", 0)
+	return err
+}
+
+func (p *textProtocolReader) ReadBool() (bool, error) {
+	args, err := p.read("Bool", 1)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return false, ProtocolError{"TextProtocol", fmt.Sprintf("invalid bool %q", args[0])}
+	}
+	return v, nil
+}
+
+func (p *textProtocolReader) ReadByte() (byte, error) {
+	args, err := p.read("Byte", 1)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return 0, ProtocolError{"TextProtocol", fmt.Sprintf("invalid byte %q", args[0])}
+	}
+	return byte(v), nil
+}
+
+func (p *textProtocolReader) ReadI16() (int16, error) {
+	v, err := p.readInt("I16", 16)
+	return int16(v), err
+}
+
+func (p *textProtocolReader) ReadI32() (int32, error) {
+	v, err := p.readInt("I32", 32)
+	return int32(v), err
+}
+
+func (p *textProtocolReader) ReadI64() (int64, error) {
+	return p.readInt("I64", 64)
+}
+
+func (p *textProtocolReader) ReadDouble() (float64, error) {
+	args, err := p.read("Double", 1)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, ProtocolError{"TextProtocol", fmt.Sprintf("invalid double %q", args[0])}
+	}
+	return v, nil
+}
+
+// ReadString also accepts Bytes() since strings and binary share a type.
+func (p *textProtocolReader) ReadString() (string, error) {
+	op, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if op == "Bytes" {
+		b, err := p.ReadBytes()
+		return string(b), err
+	}
+	args, err := p.read("String", 1)
+	if err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
+
+// ReadBytes also accepts String() since strings and binary share a type.
+func (p *textProtocolReader) ReadBytes() ([]byte, error) {
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op == "String" {
+		s, err := p.ReadString()
+		if err != nil || s == "" {
+			return nil, err
+		}
+		return []byte(s), nil
+	}
+	args, err := p.read("Bytes", 1)
+	if err != nil {
+		return nil, err
+	}
+	return parseTextBytes(args[0])
 }
@@ -0,0 +1,176 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTextProtocol(t *testing.T) {
+	b := &bytes.Buffer{}
+	testProtocol(t, NewTextProtocolReader(b), NewTextProtocolWriter(b))
+}
+
+func TestTextProtocolOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewTextProtocolWriter(buf)
+	s := &struct {
+		Str    string   `thrift:"1"`
+		List   []int16  `thrift:"2"`
+		Binary []byte   `thrift:"3"`
+		Double *float64 `thrift:"4"`
+	}{"a, \"b\")", []int16{1}, []byte{1, 2}, Float64(0.1)}
+	if err := w.WriteMessageBegin("call", MessageTypeCall, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `MessageBegin("call", 1, 0000000a)
+	StructBegin("")
+		FieldBegin("Str", 11, 1)
+			String("a, \"b\")")
+		FieldEnd()
+		FieldBegin("List", 15, 2)
+			ListBegin(6, 1)
+				I16(1)
+			ListEnd()
+		FieldEnd()
+		FieldBegin("Binary", 11, 3)
+			Bytes([1 2])
+		FieldEnd()
+		FieldBegin("Double", 4, 4)
+			Double(0.1)
+		FieldEnd()
+		FieldStop()
+	StructEnd()
+MessageEnd()
+`
+	if out := buf.String(); out != expected {
+		t.Fatalf("TextProtocol wrote\n%s\nexpected\n%s", out, expected)
+	}
+
+	r := NewTextProtocolReader(buf)
+	if _, _, seqid, err := r.ReadMessageBegin(); err != nil {
+		t.Fatal(err)
+	} else if seqid != 10 {
+		t.Fatalf("ReadMessageBegin returned seqid %d instead of 10", seqid)
+	}
+	s2 := reflect.New(reflect.TypeOf(s).Elem()).Interface()
+	if err := DecodeStruct(r, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, s2) {
+		t.Fatalf("TextProtocol round trip mismatch: %+v != %+v", s, s2)
+	}
+}
+
+func TestTextProtocolStruct(t *testing.T) {
+	i := 123
+	str := "bar"
+	ts2 := TestStruct2{"qwerty", []byte{1, 2, 3}}
+	s := &TestStruct{
+		"test",
+		&i,
+		[]string{"a", "b"},
+		map[string]string{"a": "b", "1": "2"},
+		&ts2,
+		[]*string{&str},
+		ts2,
+		[]byte{1, 2, 3},
+		[]string{"a", "b"},
+		map[string]struct{}{"i": struct{}{}, "o": struct{}{}},
+		map[string]bool{"q": true},
+		1<<31 + 2,
+		1<<63 + 2,
+		time.Second,
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodeStruct(NewTextProtocolWriter(buf), s); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &TestStruct{}
+	if err := DecodeStruct(NewTextProtocolReader(buf), s2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, s2) {
+		t.Fatalf("encdec doesn't match: %+v != %+v", s, s2)
+	}
+}
+
+func TestTextProtocolHandWritten(t *testing.T) {
+	buf := bytes.NewBufferString(`
+StructBegin(TestStructRequiredOptional)
+  FieldBegin(RequiredPtr, 11, 1)
+    String("foo")
+  FieldEnd()
+
+  FieldBegin(Required, 11, 2)
+    Bytes([98 97 114])
+  FieldEnd()
+  FieldBegin(Unknown, 8, 9)
+    I32(1)
+  FieldEnd()
+StructEnd()
+`)
+	s := &TestStructRequiredOptional{}
+	if err := DecodeStruct(NewTextProtocolReader(buf), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.RequiredPtr == nil || *s.RequiredPtr != "foo" || s.Required != "bar" {
+		t.Fatalf("TextProtocol decoded %+v", s)
+	}
+}
+
+func TestTextProtocolBadInput(t *testing.T) {
+	r := NewTextProtocolReader(bytes.NewBufferString("I32(1)\n"))
+	if _, err := r.ReadString(); err == nil {
+		t.Fatal("TextProtocol.ReadString should fail when reading an I32")
+	}
+	r = NewTextProtocolReader(bytes.NewBufferString("I32(1\n"))
+	if _, err := r.ReadI32(); err == nil {
+		t.Fatal("TextProtocol.ReadI32 should fail on a malformed line")
+	}
+}
+
+// TestTextProtocolOldOutput reads what the writer produced before names
+// and strings were quoted.
+func TestTextProtocolOldOutput(t *testing.T) {
+	buf := bytes.NewBufferString(`MessageBegin(call, 1, 0000000a)
+	StructBegin()
+		FieldBegin(RequiredPtr, 11, 1)
+			String(foo)
+		FieldEnd()
+		FieldBegin(, 11, 2)
+			Bytes([98 97 114])
+		FieldEnd()
+		FieldStop()
+	StructEnd()
+MessageEnd()
+`)
+	r := NewTextProtocolReader(buf)
+	if name, mtype, seqid, err := r.ReadMessageBegin(); err != nil || name != "call" || mtype != MessageTypeCall || seqid != 10 {
+		t.Fatalf("ReadMessageBegin returned %q %d %d %+v", name, mtype, seqid, err)
+	}
+	s := &TestStructRequiredOptional{}
+	if err := DecodeStruct(r, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.RequiredPtr == nil || *s.RequiredPtr != "foo" || s.Required != "bar" {
+		t.Fatalf("TextProtocol decoded %+v", s)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryMinBackoff  = 50 * time.Millisecond
+	defaultRetryMaxBackoff  = time.Second
+)
+
+type idempotent interface {
+	Idempotent() bool
+}
+
+// RetryClient is an RPCClient that retries calls to idempotent methods
+// which fail with a connection error. Requests are idempotent if they
+// implement Idempotent() bool returning true, which the generator adds
+// for methods annotated with (idempotent = "true"). Other calls, and
+// calls that fail with an ApplicationException or other error from the
+// server, aren't retried. Service exceptions declared in the IDL are
+// returned as part of the response so are never retried either.
+//
+// The exported fields must be set before the first call.
+type RetryClient struct {
+	Client RPCClient
+	// MaxAttempts is the most times a call is made. Defaults to 3.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the delay before a retry, which
+	// doubles with each attempt and is randomly reduced by up to half.
+	// They default to 50ms and 1s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// HedgeDelay, if set, is how long to wait for a response to an
+	// idempotent call before sending a second request. Whichever response
+	// arrives first is used.
+	HedgeDelay time.Duration
+}
+
+// NewRetryClient returns a RetryClient making calls with client.
+func NewRetryClient(client RPCClient) *RetryClient {
+	return &RetryClient{Client: client}
+}
+
+// Call invokes the named method, retrying it if it's idempotent.
+func (c *RetryClient) Call(method string, request interface{}, response interface{}) error {
+	if i, ok := request.(idempotent); !ok || !i.Idempotent() {
+		return c.Client.Call(method, request, response)
+	}
+
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultRetryMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.try(method, request, response)
+		if err == nil || !isConnError(err) || attempt >= attempts {
+			return err
+		}
+		time.Sleep(c.backoff(attempt))
+		if response != nil {
+			// Drop anything decoded before the failure
+			v := reflect.ValueOf(response).Elem()
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+}
+
+// try makes a single attempt at a call, hedging it if enabled.
+func (c *RetryClient) try(method string, request interface{}, response interface{}) error {
+	if c.HedgeDelay <= 0 || response == nil {
+		return c.Client.Call(method, request, response)
+	}
+
+	// Each request decodes into its own response as the slower one may
+	// still be running when Call returns.
+	type result struct {
+		response interface{}
+		err      error
+	}
+	results := make(chan result, 2)
+	call := func() {
+		res := reflect.New(reflect.TypeOf(response).Elem()).Interface()
+		err := c.Client.Call(method, request, res)
+		results <- result{res, err}
+	}
+
+	go call()
+	pending := 1
+	timer := time.NewTimer(c.HedgeDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			go call()
+			pending++
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				reflect.ValueOf(response).Elem().Set(reflect.ValueOf(r.response).Elem())
+				return nil
+			}
+			if pending == 0 {
+				return r.err
+			}
+		}
+	}
+}
+
+func (c *RetryClient) backoff(attempt int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = defaultRetryMinBackoff
+	}
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,112 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestIdempotentRequest struct {
+	Value int32 `thrift:"1,required"`
+}
+
+func (r *TestIdempotentRequest) Idempotent() bool {
+	return true
+}
+
+// scriptedClient returns the errors it's given in order, sleeping first
+// for the matching delay if there is one, and then succeeds.
+type scriptedClient struct {
+	mu     sync.Mutex
+	errs   []error
+	delays []time.Duration
+	calls  int
+}
+
+func (c *scriptedClient) Call(method string, request interface{}, response interface{}) error {
+	c.mu.Lock()
+	n := c.calls
+	c.calls++
+	c.mu.Unlock()
+	if n < len(c.delays) {
+		time.Sleep(c.delays[n])
+	}
+	if n < len(c.errs) && c.errs[n] != nil {
+		return c.errs[n]
+	}
+	if res, ok := response.(*TestResponse); ok {
+		res.Value = int32(n)
+	}
+	return nil
+}
+
+func (c *scriptedClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestRetryClient(t *testing.T) {
+	fail := &ApplicationException{"fail", ExceptionInternalError}
+	tests := []struct {
+		name    string
+		request interface{}
+		errs    []error
+		err     error
+		calls   int
+	}{
+		{"success", &TestIdempotentRequest{}, nil, nil, 1},
+		{"retried", &TestIdempotentRequest{}, []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF}, nil, 3},
+		{"too many attempts", &TestIdempotentRequest{}, []error{io.EOF, io.EOF, io.EOF}, io.EOF, 3},
+		{"not idempotent", &TestRequest{}, []error{io.EOF}, io.EOF, 1},
+		{"application exception", &TestIdempotentRequest{}, []error{fail}, fail, 1},
+	}
+	for _, test := range tests {
+		sc := &scriptedClient{errs: test.errs}
+		c := NewRetryClient(sc)
+		c.MinBackoff = time.Millisecond
+		res := &TestResponse{}
+		if err := c.Call("Success", test.request, res); err != test.err {
+			t.Errorf("%s: expected %+v instead of %+v", test.name, test.err, err)
+		}
+		if sc.calls != test.calls {
+			t.Errorf("%s: expected %d calls instead of %d", test.name, test.calls, sc.calls)
+		}
+		if test.err == nil && res.Value != int32(test.calls-1) {
+			t.Errorf("%s: expected the response from call %d instead of %d", test.name, test.calls-1, res.Value)
+		}
+	}
+}
+
+func TestRetryClientHedge(t *testing.T) {
+	sc := &scriptedClient{delays: []time.Duration{time.Second}}
+	c := NewRetryClient(sc)
+	c.HedgeDelay = 10 * time.Millisecond
+
+	start := time.Now()
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestIdempotentRequest{}, res); err != nil {
+		t.Fatalf("RetryClient.Call returned error: %+v", err)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Fatalf("Hedged call took %s", d)
+	}
+	if res.Value != 1 || sc.count() != 2 {
+		t.Fatalf("Expected the response to the hedged request instead of %d after %d calls", res.Value, sc.count())
+	}
+
+	// Calls that aren't idempotent aren't hedged
+	sc = &scriptedClient{delays: []time.Duration{20 * time.Millisecond}}
+	c.Client = sc
+	if err := c.Call("Success", &TestRequest{}, res); err != nil {
+		t.Fatalf("RetryClient.Call returned error: %+v", err)
+	}
+	if sc.count() != 1 {
+		t.Fatalf("Expected 1 call instead of %d", sc.count())
+	}
+}
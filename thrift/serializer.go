@@ -0,0 +1,175 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the largest buffer kept for reuse so an occasional
+// large value doesn't pin its memory.
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// Marshal returns the encoding of the struct v using protocol p.
+func Marshal(v interface{}, p ProtocolBuilder) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := p.NewProtocolWriter(buf)
+	if err := EncodeStruct(w, v); err != nil {
+		return nil, err
+	}
+	if f, ok := w.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// Unmarshal decodes data encoded with protocol p into the struct pointed
+// to by v.
+func Unmarshal(data []byte, v interface{}, p ProtocolBuilder) error {
+	return DecodeStruct(p.NewProtocolReader(bytes.NewReader(data)), v)
+}
+
+// Serializer encodes structs using a protocol, reusing its buffers and
+// protocol writers between calls. It's safe for concurrent use.
+type Serializer struct {
+	// Options are used by every call to Marshal and Append and shouldn't
+	// be changed once it's in use.
+	Options EncodeOptions
+
+	pool sync.Pool
+}
+
+type serializerState struct {
+	buf bytes.Buffer
+	w   ProtocolWriter
+}
+
+// NewSerializer returns a Serializer using protocol p.
+func NewSerializer(p ProtocolBuilder) *Serializer {
+	s := &Serializer{}
+	s.pool.New = func() interface{} {
+		st := &serializerState{}
+		st.w = p.NewProtocolWriter(&st.buf)
+		return st
+	}
+	return s
+}
+
+// Marshal returns the encoding of the struct v.
+func (s *Serializer) Marshal(v interface{}) ([]byte, error) {
+	return s.Append(nil, v)
+}
+
+// Append appends the encoding of the struct v to dst and returns the
+// extended slice. Reusing dst avoids allocating for each value.
+func (s *Serializer) Append(dst []byte, v interface{}) ([]byte, error) {
+	st := s.pool.Get().(*serializerState)
+	err := EncodeStructWithOptions(st.w, v, s.Options)
+	if err == nil {
+		if f, ok := st.w.(Flusher); ok {
+			err = f.Flush()
+		}
+	}
+	if err != nil {
+		// The writer may be part way through a value so it's not reused
+		return dst, err
+	}
+	dst = append(dst, st.buf.Bytes()...)
+	if st.buf.Cap() <= maxPooledBufferSize {
+		st.buf.Reset()
+		s.pool.Put(st)
+	}
+	return dst, nil
+}
+
+// Deserializer decodes structs using a protocol, reusing its protocol
+// readers between calls. It's safe for concurrent use. Decoded values
+// don't refer to the data they were decoded from.
+type Deserializer struct {
+	// Options are used by every call to Unmarshal and shouldn't be
+	// changed once it's in use.
+	Options DecodeOptions
+
+	pool sync.Pool
+}
+
+type deserializerState struct {
+	r  bytes.Reader
+	pr ProtocolReader
+}
+
+// NewDeserializer returns a Deserializer using protocol p.
+func NewDeserializer(p ProtocolBuilder) *Deserializer {
+	d := &Deserializer{}
+	d.pool.New = func() interface{} {
+		st := &deserializerState{}
+		st.pr = p.NewProtocolReader(&st.r)
+		return st
+	}
+	return d
+}
+
+// Unmarshal decodes data into the struct pointed to by v.
+func (d *Deserializer) Unmarshal(data []byte, v interface{}) error {
+	st := d.pool.Get().(*deserializerState)
+	st.r.Reset(data)
+	if err := DecodeStructWithOptions(st.pr, v, d.Options); err != nil {
+		// The reader may be part way through a value so it's not reused
+		return setOffset(err, int64(len(data)-st.r.Len()))
+	}
+	st.r.Reset(nil)
+	d.pool.Put(st)
+	return nil
+}
+
+// EncodedSize returns the number of bytes encoding the struct v with
+// protocol p takes without writing them anywhere. It's useful for sizing
+// frames and enforcing payload limits before sending.
+func EncodedSize(v interface{}, p ProtocolBuilder) (int, error) {
+	var n countWriter
+	w := p.NewProtocolWriter(&n)
+	if err := EncodeStruct(w, v); err != nil {
+		return 0, err
+	}
+	if f, ok := w.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return int(n), nil
+}
+
+// countWriter counts the bytes written to it and discards them.
+type countWriter int64
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	*w += countWriter(len(b))
+	return len(b), nil
+}
+
+func (w *countWriter) WriteString(s string) (int, error) {
+	*w += countWriter(len(s))
+	return len(s), nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testSerializerStruct(i int) *TestStruct2 {
+	return &TestStruct2{Str: "test", Binary: []byte{byte(i), 1, 2}}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		st := testSerializerStruct(1)
+		data, err := Marshal(st, p)
+		if err != nil {
+			t.Fatalf("Marshal returned error: %+v", err)
+		}
+		buf := &bytes.Buffer{}
+		if err := EncodeStruct(p.NewProtocolWriter(buf), st); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, buf.Bytes()) {
+			t.Fatalf("Marshal returned %x instead of %x", data, buf.Bytes())
+		}
+
+		st2 := &TestStruct2{}
+		if err := Unmarshal(data, st2, p); err != nil {
+			t.Fatalf("Unmarshal returned error: %+v", err)
+		}
+		if !reflect.DeepEqual(st, st2) {
+			t.Fatalf("Unmarshal returned %+v instead of %+v", st2, st)
+		}
+	}
+}
+
+func TestSerializer(t *testing.T) {
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		s := NewSerializer(p)
+		d := NewDeserializer(p)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var buf []byte
+				for j := 0; j < 50; j++ {
+					st := testSerializerStruct(i*50 + j)
+					var err error
+					if buf, err = s.Append(buf[:0], st); err != nil {
+						t.Errorf("Serializer.Append returned error: %+v", err)
+						return
+					}
+					st2 := &TestStruct2{}
+					if err := d.Unmarshal(buf, st2); err != nil {
+						t.Errorf("Deserializer.Unmarshal returned error: %+v", err)
+						return
+					}
+					if !reflect.DeepEqual(st, st2) {
+						t.Errorf("Deserializer.Unmarshal returned %+v instead of %+v", st2, st)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestDeserializerError(t *testing.T) {
+	s := NewSerializer(CompactProtocol)
+	d := NewDeserializer(CompactProtocol)
+	data, err := s.Marshal(testSerializerStruct(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed decode doesn't affect the next one
+	if err := d.Unmarshal(data[:len(data)-2], &TestStruct2{}); err == nil {
+		t.Fatal("Expected an error decoding truncated data")
+	}
+	st := &TestStruct2{}
+	if err := d.Unmarshal(data, st); err != nil {
+		t.Fatalf("Deserializer.Unmarshal returned error: %+v", err)
+	}
+	if !reflect.DeepEqual(st, testSerializerStruct(1)) {
+		t.Fatalf("Deserializer.Unmarshal returned %+v", st)
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	i := 123
+	str := strings.Repeat("long string ", 10)
+	ts2 := TestStruct2{str, []byte{1, 2, 3}}
+	values := []interface{}{
+		&TestEmptyStruct{},
+		testSerializerStruct(1),
+		&TestStruct{
+			String:  str,
+			Int:     &i,
+			List:    []string{"a", str},
+			Map:     map[string]string{"a": "b", "1": str},
+			Struct:  &ts2,
+			List2:   []*string{&str},
+			Struct2: ts2,
+			Binary:  bytes.Repeat([]byte{1}, 200),
+			Set:     []string{"a", "b"},
+			Set2:    map[string]struct{}{"i": {}},
+			Set3:    map[string]bool{"q": true, "p": false},
+			Uint64:  1<<63 + 2,
+		},
+	}
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		for _, v := range values {
+			data, err := Marshal(v, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := EncodedSize(v, p); err != nil {
+				t.Fatalf("EncodedSize returned error: %+v", err)
+			} else if n != len(data) {
+				t.Fatalf("EncodedSize of %T returned %d instead of %d", v, n, len(data))
+			}
+		}
+	}
+
+	if _, err := EncodedSize(&struct {
+		Str *string `thrift:"1,required"`
+	}{}, BinaryProtocol); err == nil {
+		t.Fatal("Expected EncodedSize to fail for a missing required field")
+	}
+}
+
+// Benchmarks
+
+func BenchmarkMarshal(b *testing.B) {
+	st := testSerializerStruct(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Marshal(st, BinaryProtocol)
+	}
+}
+
+func BenchmarkSerializerAppend(b *testing.B) {
+	s := NewSerializer(BinaryProtocol)
+	st := testSerializerStruct(1)
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = s.Append(buf[:0], st)
+	}
+}
+
+func BenchmarkDeserializerUnmarshal(b *testing.B) {
+	s := NewSerializer(BinaryProtocol)
+	d := NewDeserializer(BinaryProtocol)
+	data, _ := s.Marshal(testSerializerStruct(1))
+	st := &TestStruct2{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d.Unmarshal(data, st)
+	}
+}
+
+func BenchmarkEncodedSize(b *testing.B) {
+	st := testSerializerStruct(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodedSize(st, BinaryProtocol)
+	}
+}
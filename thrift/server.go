@@ -5,58 +5,97 @@
 package thrift
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net"
 	"net/rpc"
 	"strings"
 	"sync"
+	"time"
 )
 
 type serverCodec struct {
-	conn       Transport
-	nameCache  map[string]string // incoming name -> registered name
-	methodName map[uint64]string // sequence ID -> method name
-	mu         sync.Mutex
+	conn         Transport
+	interceptors interceptorChain
+	nameCache    map[string]string         // incoming name -> registered name
+	requests     map[uint64]*serverRequest // sequence ID -> request in progress
+	request      *serverRequest            // request being read
+	mu           sync.Mutex
+}
+
+type serverRequest struct {
+	method      string
+	seq         int32
+	oneway      bool
+	body        interface{}
+	intercepted int                   // number of interceptors to call After on
+	exception   *ApplicationException // sent in place of net/rpc's error if set
+	headers     *Headers              // nil if the transport doesn't carry headers
 }
 
 // ServeConn runs the Thrift RPC server on a single connection. ServeConn blocks,
 // serving the connection until the client hangs up. The caller typically invokes
 // ServeConn in a go statement.
-func ServeConn(conn Transport) {
-	rpc.ServeCodec(NewServerCodec(conn))
+func ServeConn(conn Transport, interceptors ...Interceptor) {
+	rpc.ServeCodec(NewServerCodec(conn, interceptors...))
 }
 
 // NewServerCodec returns a new rpc.ServerCodec using Thrift RPC on conn using the specified protocol.
-func NewServerCodec(conn Transport) rpc.ServerCodec {
+// The interceptors are called around each request.
+func NewServerCodec(conn Transport, interceptors ...Interceptor) rpc.ServerCodec {
 	return &serverCodec{
-		conn:       conn,
-		nameCache:  make(map[string]string, 8),
-		methodName: make(map[uint64]string, 8),
+		conn:         conn,
+		interceptors: interceptors,
+		nameCache:    make(map[string]string, 8),
+		requests:     make(map[uint64]*serverRequest, 8),
 	}
 }
 
 func (c *serverCodec) ReadRequestHeader(request *rpc.Request) error {
 	name, messageType, seq, err := c.conn.ReadMessageBegin()
 	if err != nil {
-		return err
+		return timeoutError(err, false)
 	}
-	if messageType != MessageTypeCall { // Currently don't support one way
-		return errors.New("thrift: expected Call message type")
+	if messageType != MessageTypeCall && messageType != MessageTypeOneway {
+		return errors.New("thrift: expected Call or Oneway message type")
+	}
+
+	// Multiplexed calls are routed to the service they name. The reply
+	// uses the plain method name as other implementations expect.
+	method := name
+	if i := strings.Index(name, MultiplexedSeparator); i >= 0 {
+		method = name[i+len(MultiplexedSeparator):]
 	}
 
 	// TODO: should use a limited size cache for the nameCache to avoid a possible
 	//       memory overflow from nefarious or broken clients
 	newName := c.nameCache[name]
 	if newName == "" {
-		newName = CamelCase(name)
-		if !strings.ContainsRune(newName, '.') {
-			newName = "Thrift." + newName
+		if method != name {
+			newName = name[:len(name)-len(method)-len(MultiplexedSeparator)] + "." + CamelCase(method)
+		} else {
+			newName = CamelCase(name)
+			if !strings.ContainsRune(newName, '.') {
+				newName = "Thrift." + newName
+			}
 		}
 		c.nameCache[name] = newName
 	}
 
+	c.request = &serverRequest{
+		method: method,
+		seq:    seq,
+		oneway: messageType == MessageTypeOneway,
+	}
+	if h, ok := c.conn.(HeaderReadWriter); ok {
+		c.request.headers = &Headers{
+			Read:  cloneHeaders(h.ReadHeaders()),
+			Write: make(map[string]string),
+		}
+	}
 	c.mu.Lock()
-	c.methodName[uint64(seq)] = name
+	c.requests[uint64(seq)] = c.request
 	c.mu.Unlock()
 
 	request.ServiceMethod = newName
@@ -66,35 +105,81 @@ func (c *serverCodec) ReadRequestHeader(request *rpc.Request) error {
 }
 
 func (c *serverCodec) ReadRequestBody(thriftStruct interface{}) error {
+	r := c.request
 	if thriftStruct == nil {
+		// net/rpc only discards the body when it couldn't find the method.
+		// The message is filled in from net/rpc's error.
+		r.exception = &ApplicationException{Type: ExceptionUnknownMethod}
 		if err := SkipValue(c.conn, TypeStruct); err != nil {
-			return err
-		}
-	} else {
-		if err := DecodeStruct(c.conn, thriftStruct); err != nil {
-			return err
+			return timeoutError(err, false)
 		}
+		return timeoutError(c.conn.ReadMessageEnd(), false)
+	}
+
+	if err := DecodeStruct(c.conn, thriftStruct); err != nil {
+		err = timeoutError(err, false)
+		r.exception = &ApplicationException{err.Error(), ExceptionProtocolError}
+		return err
+	}
+	if err := c.conn.ReadMessageEnd(); err != nil {
+		return timeoutError(err, false)
+	}
+	// Clients may send one-way requests as regular calls
+	if o, ok := thriftStruct.(oneway); ok && o.Oneway() {
+		r.oneway = true
+	}
+	r.body = thriftStruct
+	n, err := c.interceptors.before(r.method, r.seq, thriftStruct, r.headers)
+	r.intercepted = n
+	if err != nil {
+		// net/rpc replies with the error without calling the handler
+		r.exception = toApplicationException(err)
+		return err
 	}
-	return c.conn.ReadMessageEnd()
+	return nil
 }
 
 func (c *serverCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
 	c.mu.Lock()
-	methodName := c.methodName[response.Seq]
-	delete(c.methodName, response.Seq)
+	r := c.requests[response.Seq]
+	delete(c.requests, response.Seq)
 	c.mu.Unlock()
-	response.ServiceMethod = methodName
+	if r == nil {
+		r = &serverRequest{seq: int32(response.Seq)}
+	}
+	response.ServiceMethod = r.method
 
 	mtype := byte(MessageTypeReply)
+	var err error
 	if response.Error != "" {
 		mtype = MessageTypeException
-		etype := int32(ExceptionInternalError)
-		if strings.HasPrefix(response.Error, "rpc: can't find") {
-			etype = ExceptionUnknownMethod
+		if r.exception == nil {
+			r.exception = &ApplicationException{response.Error, ExceptionInternalError}
+		} else if r.exception.Message == "" {
+			r.exception.Message = response.Error
+		}
+		thriftStruct = r.exception
+		err = r.exception
+	}
+	if r.intercepted > 0 {
+		var res interface{}
+		if err == nil && !r.oneway {
+			res = thriftStruct
 		}
-		thriftStruct = &ApplicationException{response.Error, etype}
+		c.interceptors[:r.intercepted].after(r.method, r.seq, r.body, res, err)
+	}
+	if r.oneway {
+		// net/rpc always responds but one-way requests get no reply
+		return nil
+	}
+	if h, ok := c.conn.(HeaderReadWriter); ok && r.headers != nil {
+		setHeaders(h.WriteHeaders(), r.headers.Write)
 	}
-	if err := c.conn.WriteMessageBegin(response.ServiceMethod, mtype, int32(response.Seq)); err != nil {
+	return timeoutError(c.writeMessage(response.ServiceMethod, mtype, int32(response.Seq), thriftStruct), true)
+}
+
+func (c *serverCodec) writeMessage(name string, mtype byte, seq int32, thriftStruct interface{}) error {
+	if err := c.conn.WriteMessageBegin(name, mtype, seq); err != nil {
 		return err
 	}
 	if err := EncodeStruct(c.conn, thriftStruct); err != nil {
@@ -112,3 +197,298 @@ func (c *serverCodec) Close() error {
 	}
 	return nil
 }
+
+// ErrServerClosed is returned by Server.Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("thrift: server closed")
+
+// A TransportFactory wraps each connection accepted by a Server.
+type TransportFactory func(io.ReadWriteCloser) io.ReadWriteCloser
+
+var (
+	// BufferedTransport uses connections as they are. NewTransport adds
+	// the buffering.
+	BufferedTransport TransportFactory = func(rwc io.ReadWriteCloser) io.ReadWriteCloser { return rwc }
+	// FramedTransport wraps connections in a FramedReadWriteCloser.
+	FramedTransport TransportFactory = func(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+		return NewFramedReadWriteCloser(rwc, DefaultMaxFrameSize)
+	}
+)
+
+// Server accepts connections and serves Thrift RPC requests on them. The
+// zero value isn't usable, use NewServer.
+type Server struct {
+	// Transport wraps accepted connections. Defaults to FramedTransport.
+	Transport TransportFactory
+	// Protocol used on connections. Defaults to BinaryProtocol.
+	Protocol ProtocolBuilder
+	// MaxConns limits the number of open connections. Once reached new
+	// connections aren't accepted until one closes. Zero means no limit.
+	MaxConns int
+	// IdleTimeout closes connections that have had no requests in
+	// progress for this long. Zero means no timeout.
+	IdleTimeout time.Duration
+	// ReadTimeout and WriteTimeout limit how long reading a request and
+	// writing a response may take. Connections that take longer are
+	// closed. Unless IdleTimeout is set, ReadTimeout also closes
+	// connections that have been waiting that long for a request. They
+	// need connections that support deadlines. Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Interceptors are called around each request in order.
+	Interceptors []Interceptor
+
+	rpc *rpc.Server
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	slots     chan struct{}
+	done      chan struct{}
+}
+
+// NewServer returns a new Server with no registered services.
+func NewServer() *Server {
+	return &Server{
+		rpc:       rpc.NewServer(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Register publishes the methods of rcvr, such as a generated *XxxServer,
+// as the default service for calls that don't name one.
+func (s *Server) Register(rcvr interface{}) error {
+	return s.rpc.RegisterName("Thrift", rcvr)
+}
+
+// RegisterName publishes the methods of rcvr as the named service for
+// multiplexed calls.
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	return s.rpc.RegisterName(name, rcvr)
+}
+
+// Serve accepts connections on l, serving each in its own goroutine, until
+// l fails or Shutdown is called. It always returns a non-nil error and
+// closes l.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
+
+	for {
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+			case <-s.done:
+				return ErrServerClosed
+			}
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			if s.slots != nil {
+				<-s.slots
+			}
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			return err
+		}
+		c := s.newConn(conn)
+		if c == nil {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go c.serve()
+	}
+}
+
+// Shutdown stops the server from accepting connections, closes idle
+// connections, and waits for requests in progress to complete before
+// closing the rest. If ctx is done first the remaining connections are
+// closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		for c := range s.conns {
+			c.closeIfIdle()
+		}
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for c := range s.conns {
+				c.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+		if s.slots == nil && s.MaxConns > 0 {
+			s.slots = make(chan struct{}, s.MaxConns)
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) newConn(conn net.Conn) *serverConn {
+	tf := s.Transport
+	if tf == nil {
+		tf = FramedTransport
+	}
+	p := s.Protocol
+	if p == nil {
+		p = BinaryProtocol
+	}
+	c := &serverConn{
+		server: s,
+		conn:   conn,
+	}
+	t := NewTimeoutTransport(tf(conn), p, Timeouts{
+		Read:  s.ReadTimeout,
+		Write: s.WriteTimeout,
+		Idle:  s.IdleTimeout,
+	})
+	c.ServerCodec = NewServerCodec(t, s.Interceptors...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.conns[c] = struct{}{}
+	if s.IdleTimeout > 0 {
+		c.idle = time.AfterFunc(s.IdleTimeout, func() {
+			c.server.mu.Lock()
+			c.closeIfIdle()
+			c.server.mu.Unlock()
+		})
+	}
+	return c
+}
+
+// serverConn tracks the requests in progress on a connection so it can be
+// closed once idle. It wraps the connection's codec to do so.
+type serverConn struct {
+	rpc.ServerCodec
+	server *Server
+	conn   net.Conn
+	idle   *time.Timer
+
+	// protected by server.mu
+	active int
+	closed bool
+}
+
+func (c *serverConn) serve() {
+	c.server.rpc.ServeCodec(c)
+
+	s := c.server
+	s.mu.Lock()
+	delete(s.conns, c)
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	s.mu.Unlock()
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (c *serverConn) ReadRequestHeader(r *rpc.Request) error {
+	for {
+		err := c.ServerCodec.ReadRequestHeader(r)
+		if err == nil {
+			break
+		}
+		if err != ErrIdleTimeout || !c.busy() {
+			return err
+		}
+		// The wait for the next request timed out before any of it was
+		// read but the connection isn't idle while requests are in
+		// progress
+	}
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.closed {
+		// Closed as idle while the request was arriving
+		return io.EOF
+	}
+	c.active++
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	return nil
+}
+
+func (c *serverConn) WriteResponse(r *rpc.Response, body interface{}) error {
+	err := c.ServerCodec.WriteResponse(r, body)
+	s := c.server
+	s.mu.Lock()
+	c.active--
+	if c.active == 0 {
+		if c.idle != nil {
+			c.idle.Reset(s.IdleTimeout)
+		}
+		select {
+		case <-s.done:
+			c.closeIfIdle()
+		default:
+		}
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (c *serverConn) busy() bool {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	return c.active > 0 && !c.closed
+}
+
+// closeIfIdle closes the connection if no requests are in progress. The
+// server's lock must be held.
+func (c *serverConn) closeIfIdle() {
+	if c.active == 0 && !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
@@ -6,10 +6,20 @@ package thrift
 
 import (
 	"bytes"
+	"context"
+	"net"
 	"net/rpc"
 	"testing"
+	"time"
 )
 
+type TestOtherService int
+
+func (s *TestOtherService) Success(req *TestRequest, res *TestResponse) error {
+	res.Value = -req.Value
+	return nil
+}
+
 // Make sure the ServerCodec returns the same method name
 // in the response as was in the request.
 func TestServerMethodName(t *testing.T) {
@@ -58,3 +68,236 @@ func TestServerMethodName(t *testing.T) {
 		t.Fatalf("Expected ServiceMethod of '%s' instead of '%s'", req.ServiceMethod, res2.ServiceMethod)
 	}
 }
+
+func TestServerMultiplexed(t *testing.T) {
+	server := rpc.NewServer()
+	server.RegisterName("Thrift", new(TestService))
+	server.RegisterName("Other", new(TestOtherService))
+
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewServerCodec(NewTransport(NewFramedReadWriteCloser(srv, 0), BinaryProtocol)))
+	c := NewClient(NewTransport(NewFramedReadWriteCloser(cli, 0), BinaryProtocol), false)
+	defer c.Close()
+
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 123 {
+		t.Fatalf("Expected default service to return 123 instead of %d", res.Value)
+	}
+	if err := NewMultiplexedClient(c, "Other").Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != -123 {
+		t.Fatalf("Expected multiplexed service to return -123 instead of %d", res.Value)
+	}
+	if err := NewMultiplexedClient(c, "Missing").Call("Success", &TestRequest{123}, res); err == nil {
+		t.Fatal("Expected an error calling an unregistered service")
+	}
+}
+
+// Replies to multiplexed calls should use the plain method name.
+func TestServerMultiplexedMethodName(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	clientConn := NewTransport(buf, BinaryProtocol)
+	serverCodec := NewServerCodec(NewTransport(buf, BinaryProtocol))
+	if err := clientConn.WriteMessageBegin("Other:some_method", MessageTypeCall, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(clientConn, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientConn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var req rpc.Request
+	if err := serverCodec.ReadRequestHeader(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ServiceMethod != "Other.SomeMethod" {
+		t.Fatalf("Expected ServiceMethod Other.SomeMethod instead of %s", req.ServiceMethod)
+	}
+	if err := serverCodec.ReadRequestBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := serverCodec.WriteResponse(&rpc.Response{Seq: req.Seq}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if name, _, _, err := clientConn.ReadMessageBegin(); err != nil {
+		t.Fatal(err)
+	} else if name != "some_method" {
+		t.Fatalf("Expected reply name some_method instead of %s", name)
+	}
+}
+
+type TestBlockingService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *TestBlockingService) Block(req *TestRequest, res *TestResponse) error {
+	s.started <- struct{}{}
+	<-s.release
+	res.Value = req.Value
+	return nil
+}
+
+func startTestServer(s *Server) (string, chan error) {
+	l, addr := listenTCP()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+	return addr, errs
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	if err := s.Register(new(TestService)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterName("Other", new(TestOtherService)); err != nil {
+		t.Fatal(err)
+	}
+	addr, errs := startTestServer(s)
+
+	c, err := Dial("tcp", addr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 123 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, 123)
+	}
+	if err := NewMultiplexedClient(c, "Other").Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != -123 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, -123)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != ErrServerClosed {
+		t.Fatalf("Expected ErrServerClosed from Serve instead of %+v", err)
+	}
+	if err := c.Call("Success", &TestRequest{123}, res); err == nil {
+		t.Fatal("Expected an error calling a server that's shut down")
+	}
+}
+
+func TestServerShutdownDrain(t *testing.T) {
+	svc := &TestBlockingService{make(chan struct{}), make(chan struct{})}
+	s := NewServer()
+	s.Transport = BufferedTransport
+	s.Protocol = CompactProtocol
+	s.Register(svc)
+	addr, _ := startTestServer(s)
+
+	c, err := Dial("tcp", addr, false, CompactProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	res := &TestResponse{}
+	call := c.Go("Block", &TestRequest{5}, res, nil)
+	<-svc.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %+v with a request in progress", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(svc.release)
+	if err := (<-call.Done).Error; err != nil {
+		t.Fatalf("Request in progress failed during shutdown: %+v", err)
+	} else if res.Value != 5 {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, 5)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	svc := &TestBlockingService{make(chan struct{}), make(chan struct{})}
+	defer close(svc.release)
+	s := NewServer()
+	s.Register(svc)
+	addr, _ := startTestServer(s)
+
+	c, err := Dial("tcp", addr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Go("Block", &TestRequest{5}, &TestResponse{}, nil)
+	<-svc.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded from Shutdown instead of %+v", err)
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s := NewServer()
+	s.MaxConns = 1
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	c1, err := Dial("tcp", addr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := Dial("tcp", addr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	call := c2.Go("Success", &TestRequest{2}, &TestResponse{}, nil)
+	select {
+	case <-call.Done:
+		t.Fatal("Second connection was served while over MaxConns")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c1.Close()
+	if err := (<-call.Done).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	s := NewServer()
+	s.IdleTimeout = 20 * time.Millisecond
+	s.Register(new(TestService))
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	c, err := Dial("tcp", addr, true, BinaryProtocol, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Call("Success", &TestRequest{1}, &TestResponse{}); err == nil {
+		t.Fatal("Expected the idle connection to be closed")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -133,6 +134,10 @@ func (e *ApplicationException) String() string {
 	return fmt.Sprintf("%s: %s", typeStr, e.Message)
 }
 
+func (e *ApplicationException) Error() string {
+	return e.String()
+}
+
 func fieldType(t reflect.Type) byte {
 	switch t.Kind() {
 	case reflect.Bool:
@@ -192,7 +197,7 @@ func isEmptyValue(v reflect.Value) bool {
 // encodeField contains information about how to encode a field of a
 // struct.
 type encodeField struct {
-	i         int // field index in struct
+	index     []int // field index in struct, more than one for promoted fields
 	id        int
 	required  bool
 	keepEmpty bool
@@ -204,6 +209,21 @@ type structMeta struct {
 	required   *BitSet // bitmap of required fields
 	orderedIds []int
 	fields     map[int]encodeField
+	unknown    []int // index of the UnknownFields field if there is one
+	err        error
+}
+
+// DuplicateFieldIDError is returned when encoding or decoding a struct in
+// which two fields, including those promoted from embedded structs, have
+// the same ID.
+type DuplicateFieldIDError struct {
+	StructName string
+	ID         int
+	Fields     [2]string
+}
+
+func (e *DuplicateFieldIDError) Error() string {
+	return fmt.Sprintf("thrift: duplicate field id %d in %s: %s and %s", e.ID, e.StructName, e.Fields[0], e.Fields[1])
 }
 
 var (
@@ -213,71 +233,191 @@ var (
 
 // encodeFields returns a slice of encodeField for a given
 // struct type.
-func encodeFields(t reflect.Type) structMeta {
+func encodeFields(t reflect.Type) (structMeta, error) {
 	typeCacheLock.RLock()
 	m, ok := encodeFieldsCache[t]
 	typeCacheLock.RUnlock()
 	if ok {
-		return m
+		return m, m.err
 	}
 
 	typeCacheLock.Lock()
 	defer typeCacheLock.Unlock()
 	m, ok = encodeFieldsCache[t]
 	if ok {
-		return m
+		return m, m.err
 	}
 
 	fs := make(map[int]encodeField)
 	m = structMeta{fields: fs}
 	m.required = new(BitSet)
-	v := reflect.Zero(t)
-	n := v.NumField()
-	for i := 0; i < n; i++ {
-		f := t.Field(i)
-		if f.PkgPath != "" && !f.Anonymous {
+	var fields []taggedField
+	unknownDepth := -1
+	for _, f := range typeFields(t) {
+		if f.unknown {
+			if unknownDepth < 0 || len(f.index) < unknownDepth {
+				m.unknown = f.index
+				unknownDepth = len(f.index)
+			}
 			continue
 		}
-		if f.Anonymous {
-			// We want to do a better job with these later,
-			// so for now pretend they don't exist.
-			continue
+		fields = append(fields, f)
+	}
+	for _, f := range fields {
+		ef := f.encodeField
+		if other, ok := fs[ef.id]; ok {
+			m.err = &DuplicateFieldIDError{
+				StructName: t.Name(),
+				ID:         ef.id,
+				Fields:     [2]string{fieldPath(t, other.index), fieldPath(t, ef.index)},
+			}
+			break
+		}
+		if ef.required {
+			m.required.Set(ef.id)
 		}
-		tv := f.Tag.Get("thrift")
-		if tv != "" {
-			var ef encodeField
-			ef.i = i
-			ef.id = 0
+		fs[ef.id] = ef
+	}
+
+	m.orderedIds = make([]int, 0, len(m.fields))
+	for idx := range m.fields {
+		m.orderedIds = append(m.orderedIds, idx)
+	}
+	sort.Ints(m.orderedIds)
 
-			if tv == "-" {
+	encodeFieldsCache[t] = m
+	return m, m.err
+}
+
+type taggedField struct {
+	encodeField
+	unknown bool // an UnknownFields field
+}
+
+// typeFields returns the tagged fields of struct type t including those
+// promoted from embedded structs. As with Go's own promotion a field is
+// hidden by one with the same name nearer the top, and fields with the
+// same name at the same depth hide each other. Embedded structs with a
+// thrift tag are encoded as a struct rather than promoting their fields.
+func typeFields(t reflect.Type) []taggedField {
+	type candidate struct {
+		taggedField
+		depth int
+	}
+	var candidates []candidate
+	names := make(map[string][]int) // depths of every field with the name
+
+	var walk func(t reflect.Type, index []int, visited map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, visited map[reflect.Type]bool) {
+		visited[t] = true
+		defer delete(visited, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fi := append(index[:len(index):len(index)], i)
+			names[f.Name] = append(names[f.Name], len(fi))
+			tv := f.Tag.Get("thrift")
+			if f.Anonymous && tv == "" {
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					// Pointers to unexported types can't be allocated
+					if f.PkgPath != "" {
+						continue
+					}
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !visited[ft] {
+					walk(ft, fi, visited)
+				}
+				continue
+			}
+			if f.PkgPath != "" || tv == "" || tv == "-" {
 				continue
 			}
 			id, opts := parseTag(tv)
-			ef.id = id
-			ef.name = f.Name
-			ef.required = opts.Contains("required")
-			if ef.required {
-				m.required.Set(id)
+			if strings.HasPrefix(tv, "-,") {
+				if opts.Contains("unknown") && f.Type == unknownFieldsType {
+					candidates = append(candidates, candidate{taggedField{encodeField{index: fi, name: f.Name}, true}, len(fi)})
+				}
+				continue
+			}
+			ef := encodeField{
+				index:     fi,
+				id:        id,
+				name:      f.Name,
+				required:  opts.Contains("required"),
+				keepEmpty: opts.Contains("keepempty"),
 			}
-			ef.keepEmpty = opts.Contains("keepempty")
 			if opts.Contains("set") {
 				ef.fieldType = TypeSet
 			} else {
 				ef.fieldType = fieldType(f.Type)
 			}
+			candidates = append(candidates, candidate{taggedField{encodeField: ef}, len(fi)})
+		}
+	}
+	walk(t, nil, make(map[reflect.Type]bool))
+
+	fields := make([]taggedField, 0, len(candidates))
+	for _, c := range candidates {
+		depths := names[c.name]
+		top, n := depths[0], 0
+		for _, d := range depths {
+			if d < top {
+				top, n = d, 0
+			}
+			if d == top {
+				n++
+			}
+		}
+		if c.depth == top && n == 1 {
+			fields = append(fields, c.taggedField)
+		}
+	}
+	return fields
+}
 
-			fs[ef.id] = ef
+// fieldPath returns the dotted name of the field at index in t.
+func fieldPath(t reflect.Type, index []int) string {
+	var path []string
+	for _, i := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
 		}
+		f := t.Field(i)
+		path = append(path, f.Name)
+		t = f.Type
 	}
+	return strings.Join(path, ".")
+}
 
-	m.orderedIds = make([]int, 0, len(m.fields))
-	for idx := range m.fields {
-		m.orderedIds = append(m.orderedIds, idx)
+// fieldByIndex returns the field of v at index or an invalid Value if
+// it's in an embedded struct through a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
 	}
-	sort.Ints(m.orderedIds)
+	return v
+}
 
-	encodeFieldsCache[t] = m
-	return m
+// fieldByIndexAlloc is like fieldByIndex but allocates nil embedded
+// structs.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
 }
 
 func SkipValue(r ProtocolReader, thriftType byte) error {
@@ -296,7 +436,9 @@ func SkipValue(r ProtocolReader, thriftType byte) error {
 	case TypeDouble:
 		_, err = r.ReadDouble()
 	case TypeString:
-		_, err = r.ReadBytes()
+		// Strings and binary share a type but not every protocol encodes
+		// them the same way (e.g. JSON uses base64 for binary).
+		_, err = r.ReadString()
 	case TypeStruct:
 		if err := r.ReadStructBegin(); err != nil {
 			return err
@@ -17,7 +17,10 @@ type EncodeFieldsTestStruct struct {
 
 func TestEncodeFields(t *testing.T) {
 	s := EncodeFieldsTestStruct{}
-	m := encodeFields(reflect.TypeOf(s))
+	m, err := encodeFields(reflect.TypeOf(s))
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(m.fields) != 3 {
 		t.Fatalf("Did not find all fields. %d fields, expected 3 fields", len(m.fields))
 	}
@@ -25,3 +28,91 @@ func TestEncodeFields(t *testing.T) {
 		t.Fatalf("Type map[...]struct{} not handled as a Set")
 	}
 }
+
+type EmbeddedHeader struct {
+	TraceID int64  `thrift:"100"`
+	Shadow  string `thrift:"101"`
+}
+
+type EmbeddedBody struct {
+	Body []byte `thrift:"200"`
+}
+
+type embeddedAudit struct {
+	User string `thrift:"102,required"`
+}
+
+type EmbeddedStruct struct {
+	EmbeddedHeader
+	*embeddedAudit // unexported pointers can't be allocated so are ignored
+	*EmbeddedBody
+	Named  EmbeddedHeader `thrift:"3"`
+	Value  int32          `thrift:"1"`
+	Shadow string         `thrift:"4"`
+}
+
+type EmbeddedConflictStruct struct {
+	EmbeddedHeader
+	ID int64 `thrift:"100"`
+}
+
+func TestEncodeFieldsEmbedded(t *testing.T) {
+	m, err := encodeFields(reflect.TypeOf(EmbeddedStruct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, id := range m.orderedIds {
+		names = append(names, m.fields[id].name)
+	}
+	expected := []string{"Value", "Named", "Shadow", "TraceID", "Body"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected fields %v instead of %v", expected, names)
+	}
+
+	_, err = encodeFields(reflect.TypeOf(EmbeddedConflictStruct{}))
+	if e, ok := err.(*DuplicateFieldIDError); !ok || e.ID != 100 || e.Fields != [2]string{"EmbeddedHeader.TraceID", "ID"} {
+		t.Fatalf("Expected a DuplicateFieldIDError instead of %+v", err)
+	}
+	// The error is returned by the codec and from the cache
+	if err := EncodeStruct(NewBinaryProtocolWriter(nullWriter(0), true), &EmbeddedConflictStruct{}); err == nil {
+		t.Fatal("Expected EncodeStruct to return an error")
+	}
+}
+
+func TestEncodeDecodeEmbedded(t *testing.T) {
+	s := &EmbeddedStruct{
+		EmbeddedHeader: EmbeddedHeader{TraceID: 1234, Shadow: "hidden"},
+		EmbeddedBody:   &EmbeddedBody{Body: []byte{1, 2}},
+		Named:          EmbeddedHeader{TraceID: 5},
+		Value:          1,
+		Shadow:         "shadow",
+	}
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol} {
+		data, err := Marshal(s, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s2 := &EmbeddedStruct{}
+		if err := Unmarshal(data, s2, p); err != nil {
+			t.Fatal(err)
+		}
+		expected := *s
+		expected.EmbeddedHeader.Shadow = ""
+		if !reflect.DeepEqual(&expected, s2) {
+			t.Fatalf("Expected %+v instead of %+v", &expected, s2)
+		}
+
+		// Fields in a nil embedded pointer are left out
+		data, err = Marshal(&EmbeddedStruct{Value: 2}, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s2 = &EmbeddedStruct{}
+		if err := Unmarshal(data, s2, p); err != nil {
+			t.Fatal(err)
+		} else if s2.EmbeddedBody != nil || s2.Value != 2 {
+			t.Fatalf("Expected a nil embedded pointer instead of %+v", s2)
+		}
+	}
+}
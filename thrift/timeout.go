@@ -0,0 +1,217 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TimeoutError is the error returned by the client and server codecs when
+// a message isn't read or written before a transport timeout. It
+// implements net.Error.
+type TimeoutError struct {
+	Op string // "idle", "read" or "write"
+}
+
+func (e *TimeoutError) Error() string {
+	return "thrift: " + e.Op + " timeout"
+}
+
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+var (
+	// ErrIdleTimeout is returned by a transport from NewTimeoutTransport
+	// when no part of the next message arrives in time. Nothing has been
+	// read so the connection can still be used.
+	ErrIdleTimeout error = &TimeoutError{"idle"}
+	// ErrReadTimeout is returned when a message isn't read in time.
+	ErrReadTimeout error = &TimeoutError{"read"}
+	// ErrWriteTimeout is returned when a message isn't written in time.
+	ErrWriteTimeout error = &TimeoutError{"write"}
+)
+
+// Timeouts are the limits enforced by a transport returned by
+// NewTimeoutTransport. Zero means no limit.
+type Timeouts struct {
+	// Read limits how long reading a message may take. The deadline is set
+	// before ReadMessageBegin and cleared after ReadMessageEnd so it
+	// includes waiting for the message to arrive.
+	Read time.Duration
+	// Write limits how long writing a message may take. The deadline is
+	// set before WriteMessageBegin and cleared after Flush.
+	Write time.Duration
+	// Idle, if set, is used in place of Read until the first byte of a
+	// message arrives. It lets servers keep connections open between
+	// requests for longer than a request may take to arrive once started.
+	Idle time.Duration
+}
+
+type deadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// NewTimeoutTransport is like NewTransport but applies timeouts to each
+// message using the connection's deadlines. rwc must implement
+// SetReadDeadline and SetWriteDeadline, as net.Conn and the framed and
+// header transports wrapping one do, otherwise the timeouts are ignored.
+func NewTimeoutTransport(rwc io.ReadWriteCloser, p ProtocolBuilder, timeouts Timeouts) Transport {
+	t := NewTransport(rwc, p)
+	d, ok := rwc.(deadlineSetter)
+	if !ok || timeouts == (Timeouts{}) || d.SetReadDeadline(time.Time{}) != nil {
+		return t
+	}
+	tt := &timeoutTransport{Transport: t, w: t.(messageWaiter), conn: d, timeouts: timeouts}
+	if h, ok := t.(*headerTransport); ok {
+		return &timeoutHeaderTransport{tt, h.h}
+	}
+	return tt
+}
+
+// messageWaiter is implemented by the transports that can wait for the
+// next message to start arriving without reading any of it.
+type messageWaiter interface {
+	waitMessage() error
+}
+
+type timeoutTransport struct {
+	Transport
+	w        messageWaiter
+	conn     deadlineSetter
+	timeouts Timeouts
+
+	mu            sync.Mutex // protects writeDeadline
+	writeDeadline time.Time
+}
+
+// ReadMessageBegin returns ErrIdleTimeout if the deadline passes before
+// the message starts to arrive. A timeout after that leaves the
+// connection part way through a message.
+func (t *timeoutTransport) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if t.timeouts.Idle > 0 {
+		err = t.conn.SetReadDeadline(time.Now().Add(t.timeouts.Idle))
+	} else if t.timeouts.Read > 0 {
+		err = t.conn.SetReadDeadline(time.Now().Add(t.timeouts.Read))
+	}
+	if err != nil {
+		return
+	}
+	if err = t.w.waitMessage(); err != nil {
+		if isTimeout(err) {
+			err = ErrIdleTimeout
+		}
+		return
+	}
+	if t.timeouts.Idle > 0 {
+		if t.timeouts.Read > 0 {
+			err = t.conn.SetReadDeadline(time.Now().Add(t.timeouts.Read))
+		} else {
+			err = t.conn.SetReadDeadline(time.Time{})
+		}
+		if err != nil {
+			return
+		}
+	}
+	return t.Transport.ReadMessageBegin()
+}
+
+func (t *timeoutTransport) ReadMessageEnd() error {
+	if err := t.Transport.ReadMessageEnd(); err != nil {
+		return err
+	}
+	return t.conn.SetReadDeadline(time.Time{})
+}
+
+func (t *timeoutTransport) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if t.timeouts.Write > 0 {
+		t.mu.Lock()
+		d := time.Now().Add(t.timeouts.Write)
+		if !t.writeDeadline.IsZero() && t.writeDeadline.Before(d) {
+			d = t.writeDeadline
+		}
+		err := t.conn.SetWriteDeadline(d)
+		t.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return t.Transport.WriteMessageBegin(name, messageType, seqid)
+}
+
+func (t *timeoutTransport) Flush() error {
+	if err := t.Transport.Flush(); err != nil {
+		return err
+	}
+	if t.timeouts.Write > 0 {
+		return t.SetWriteDeadline(time.Time{})
+	}
+	return nil
+}
+
+// SetWriteDeadline sets a deadline for writing messages. The Write
+// timeout still applies if it's sooner.
+func (t *timeoutTransport) SetWriteDeadline(d time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeDeadline = d
+	return t.conn.SetWriteDeadline(d)
+}
+
+type timeoutHeaderTransport struct {
+	*timeoutTransport
+	h HeaderReadWriter
+}
+
+func (t *timeoutHeaderTransport) ReadHeaders() map[string]string {
+	return t.h.ReadHeaders()
+}
+
+func (t *timeoutHeaderTransport) WriteHeaders() map[string]string {
+	return t.h.WriteHeaders()
+}
+
+// errNoDeadline is returned when setting a deadline on a connection that
+// doesn't support them.
+var errNoDeadline = errors.New("thrift: connection doesn't support deadlines")
+
+func setReadDeadline(rwc io.ReadWriteCloser, t time.Time) error {
+	if d, ok := rwc.(deadlineSetter); ok {
+		return d.SetReadDeadline(t)
+	}
+	return errNoDeadline
+}
+
+func setWriteDeadline(rwc io.ReadWriteCloser, t time.Time) error {
+	if d, ok := rwc.(deadlineSetter); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return errNoDeadline
+}
+
+// isTimeout reports whether err is a timeout from a connection deadline.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// timeoutError returns ErrReadTimeout or ErrWriteTimeout in place of a
+// timeout from a connection deadline and otherwise err.
+func timeoutError(err error, write bool) error {
+	if err == nil || !isTimeout(err) {
+		return err
+	}
+	if _, ok := err.(*TimeoutError); ok {
+		return err
+	}
+	if write {
+		return ErrWriteTimeout
+	}
+	return ErrReadTimeout
+}
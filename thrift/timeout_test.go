@@ -0,0 +1,204 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTransportClient(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	// Read requests but never reply
+	go io.Copy(ioutil.Discard, srv)
+
+	c := NewClient(NewTimeoutTransport(cli, BinaryProtocol, Timeouts{Read: 20 * time.Millisecond}), false)
+	defer c.Close()
+	if err := c.Call("Success", &TestRequest{1}, &TestResponse{}); err != ErrReadTimeout {
+		t.Fatalf("Expected ErrReadTimeout instead of %+v", err)
+	}
+
+	cli, srv = net.Pipe()
+	defer srv.Close()
+	cc := NewContextClient(NewTimeoutTransport(cli, BinaryProtocol, Timeouts{Write: 20 * time.Millisecond}))
+	defer cc.Close()
+	// Nothing reads from srv so the write blocks
+	if err := cc.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{}); err != ErrWriteTimeout {
+		t.Fatalf("Expected ErrWriteTimeout instead of %+v", err)
+	}
+}
+
+func TestTimeoutTransportIdleClient(t *testing.T) {
+	once.Do(startServer)
+
+	timeouts := Timeouts{Read: 20 * time.Millisecond, Write: 20 * time.Millisecond}
+	conn, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(NewTimeoutTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol, timeouts), false)
+	defer c.Close()
+
+	conn, err = net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := NewContextClient(NewTimeoutTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol, timeouts))
+	defer cc.Close()
+
+	// Connections without calls in progress stay open past the read timeout
+	for i := int32(1); i <= 2; i++ {
+		res := &TestResponse{}
+		if err := c.Call("Success", &TestRequest{i}, res); err != nil {
+			t.Fatalf("Client.Call returned error: %+v", err)
+		} else if res.Value != i {
+			t.Fatalf("Response value wrong: %d != %d", res.Value, i)
+		}
+		if err := cc.Call(context.Background(), "Success", &TestRequest{i}, res); err != nil {
+			t.Fatalf("Client.Call returned error: %+v", err)
+		}
+		time.Sleep(3 * timeouts.Read)
+	}
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+	s.ReadTimeout = 20 * time.Millisecond
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	// A client that stalls part way through a request is disconnected
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0, 0, 0, 100, 0x80}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the server to close the connection instead of %+v", err)
+	}
+}
+
+func TestServerReadTimeoutBusy(t *testing.T) {
+	svc := &TestBlockingService{make(chan struct{}), make(chan struct{})}
+	s := NewServer()
+	s.Register(svc)
+	s.ReadTimeout = 20 * time.Millisecond
+	addr, _ := startTestServer(s)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tr := NewTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol)
+	if err := tr.WriteMessageBegin("Block", MessageTypeCall, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(tr, &TestRequest{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	<-svc.started
+
+	// Waiting for the next request doesn't time out while one is in
+	// progress but a request that stalls part way still does. The
+	// connection is closed once the request in progress is answered.
+	time.Sleep(3 * s.ReadTimeout)
+	if _, err := conn.Write([]byte{0, 0, 0, 100, 0x80}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * s.ReadTimeout)
+	close(svc.release)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, seq, err := tr.ReadMessageBegin(); err != nil || seq != 1 {
+		t.Fatalf("Expected the response to request 1 instead of %d and %+v", seq, err)
+	}
+	if err := DecodeStruct(tr, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the server to close the connection instead of %+v", err)
+	}
+}
+
+func TestTimeoutTransportPartialRead(t *testing.T) {
+	transports := []struct {
+		name string
+		wrap func(net.Conn) io.ReadWriteCloser
+	}{
+		{"unframed", func(c net.Conn) io.ReadWriteCloser { return c }},
+		{"framed", func(c net.Conn) io.ReadWriteCloser { return NewFramedReadWriteCloser(c, 0) }},
+		{"header", func(c net.Conn) io.ReadWriteCloser { return NewHeaderTransport(c, 0) }},
+	}
+	for _, tr := range transports {
+		cli, srv := net.Pipe()
+		tt := NewTimeoutTransport(tr.wrap(cli), BinaryProtocol, Timeouts{Read: 20 * time.Millisecond})
+
+		// Nothing arrives so nothing is read
+		if _, _, _, err := tt.ReadMessageBegin(); err != ErrIdleTimeout {
+			t.Fatalf("%s: expected ErrIdleTimeout instead of %+v", tr.name, err)
+		}
+
+		// The message starts to arrive but stalls
+		go srv.Write([]byte{0x80, 0x01})
+		if _, _, _, err := tt.ReadMessageBegin(); err == ErrIdleTimeout || !isTimeout(err) {
+			t.Fatalf("%s: expected a read timeout instead of %+v", tr.name, err)
+		}
+		cli.Close()
+		srv.Close()
+	}
+}
+
+func TestTimeoutClientPartialRead(t *testing.T) {
+	timeouts := Timeouts{Read: 20 * time.Millisecond}
+	newClients := []struct {
+		name string
+		new  func(Transport) RPCClient
+	}{
+		{"Client", func(t Transport) RPCClient { return NewNetRPCClient(t, false) }},
+		{"ContextClient", func(t Transport) RPCClient { return NewContextClient(t).WithContext(context.Background()) }},
+	}
+	for _, nc := range newClients {
+		cli, srv := net.Pipe()
+		c := nc.new(NewTimeoutTransport(cli, BinaryProtocol, timeouts))
+
+		// Idle timeouts leave the connection open
+		time.Sleep(3 * timeouts.Read)
+		srv.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := srv.Write([]byte{0x80, 0x01}); err != nil {
+			t.Fatalf("%s: expected the connection to be open instead of %+v", nc.name, err)
+		}
+
+		// A message that stalls part way closes it
+		srv.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := srv.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%s: expected the client to close the connection instead of %+v", nc.name, err)
+		}
+		if err := c.Call("Success", &TestRequest{1}, &TestResponse{}); err == nil {
+			t.Fatalf("%s: expected the call to fail", nc.name)
+		}
+		srv.Close()
+	}
+}
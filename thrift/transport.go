@@ -7,6 +7,7 @@ package thrift
 import (
 	"bufio"
 	"io"
+	"time"
 )
 
 type Transport interface {
@@ -20,24 +21,45 @@ type transport struct {
 	ProtocolReader
 	ProtocolWriter
 	io.Closer
-	f Flusher
+	rwc io.ReadWriteCloser
+	f   Flusher
+	w   messageWaiter
 }
 
 func NewTransport(rwc io.ReadWriteCloser, p ProtocolBuilder) Transport {
 	t := &transport{
 		Closer: rwc,
+		rwc:    rwc,
 	}
-	if _, ok := rwc.(*FramedReadWriteCloser); ok {
-		t.ProtocolReader = p.NewProtocolReader(rwc)
-		t.ProtocolWriter = p.NewProtocolWriter(rwc)
+	var r io.Reader = rwc
+	var w io.Writer = rwc
+	switch rwc.(type) {
+	case *FramedReadWriteCloser, *HeaderTransport:
 		if f, ok := rwc.(Flusher); ok {
 			t.f = f
 		}
+		t.w = rwc.(messageWaiter)
+	default:
+		br := bufio.NewReader(rwc)
+		bw := bufio.NewWriter(rwc)
+		r = br
+		w = bw
+		t.f = bw
+		t.w = bufferedReader{br}
+	}
+	if b, ok := p.(ProtocolReadWriterBuilder); ok {
+		prw := b.NewProtocolReadWriter(r, w)
+		t.ProtocolReader = prw
+		t.ProtocolWriter = prw
+		if f, ok := prw.(Flusher); ok {
+			t.f = f
+		}
 	} else {
-		w := bufio.NewWriter(rwc)
+		t.ProtocolReader = p.NewProtocolReader(r)
 		t.ProtocolWriter = p.NewProtocolWriter(w)
-		t.ProtocolReader = p.NewProtocolReader(bufio.NewReader(rwc))
-		t.f = w
+	}
+	if h, ok := rwc.(HeaderReadWriter); ok {
+		return &headerTransport{t, h}
 	}
 	return t
 }
@@ -48,3 +70,38 @@ func (t *transport) Flush() error {
 	}
 	return nil
 }
+
+// SetWriteDeadline sets the write deadline of the connection if it
+// supports them.
+func (t *transport) SetWriteDeadline(d time.Time) error {
+	return setWriteDeadline(t.rwc, d)
+}
+
+func (t *transport) waitMessage() error {
+	return t.w.waitMessage()
+}
+
+// bufferedReader waits for messages on an unframed connection
+type bufferedReader struct {
+	*bufio.Reader
+}
+
+func (r bufferedReader) waitMessage() error {
+	_, err := r.Peek(1)
+	return err
+}
+
+// headerTransport is returned by NewTransport for connections that
+// support headers so they're accessible from the Transport.
+type headerTransport struct {
+	*transport
+	h HeaderReadWriter
+}
+
+func (t *headerTransport) ReadHeaders() map[string]string {
+	return t.h.ReadHeaders()
+}
+
+func (t *headerTransport) WriteHeaders() map[string]string {
+	return t.h.WriteHeaders()
+}
@@ -0,0 +1,208 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// UnknownField is a field read by DecodeStruct that the struct being
+// decoded doesn't define.
+type UnknownField struct {
+	ID   int16
+	Type byte
+	// Value is the field's value encoded with the binary protocol.
+	Value []byte
+}
+
+// UnknownFields preserves fields from a newer version of a struct across
+// a decode and encode. Add a field of this type tagged `thrift:"-,unknown"`
+// to a struct and DecodeStruct stores the fields it doesn't recognize in
+// it rather than discarding them. EncodeStruct writes them back out
+// ordered by field ID among the known fields, skipping any with the ID of
+// a field the struct defines.
+//
+// Strings and binary values share a type so an unknown binary value is
+// kept as the protocol encodes it as a string. For the JSON protocol
+// that's base64, so fields read with it must be written with it too. The
+// binary and compact protocols can be mixed freely.
+type UnknownFields []UnknownField
+
+var unknownFieldsType = reflect.TypeOf(UnknownFields(nil))
+
+func readUnknownField(r ProtocolReader, id int16, thriftType byte) (UnknownField, error) {
+	buf := &bytes.Buffer{}
+	if err := copyValue(r, NewBinaryProtocolWriter(buf, true), thriftType); err != nil {
+		return UnknownField{}, err
+	}
+	return UnknownField{ID: id, Type: thriftType, Value: buf.Bytes()}, nil
+}
+
+func writeUnknownField(w ProtocolWriter, f UnknownField) error {
+	if err := w.WriteFieldBegin("", f.Type, f.ID); err != nil {
+		return err
+	}
+	if err := copyValue(NewBinaryProtocolReader(bytes.NewReader(f.Value), false), w, f.Type); err != nil {
+		return err
+	}
+	return w.WriteFieldEnd()
+}
+
+// sortedUnknownFields returns fields ordered by ID, copying them if
+// they're not already.
+func sortedUnknownFields(fields UnknownFields) UnknownFields {
+	less := func(i, j int) bool { return fields[i].ID < fields[j].ID }
+	if sort.SliceIsSorted(fields, less) {
+		return fields
+	}
+	fields = append(UnknownFields(nil), fields...)
+	sort.SliceStable(fields, less)
+	return fields
+}
+
+// copyValue reads a value of type thriftType from r and writes it to w.
+func copyValue(r ProtocolReader, w ProtocolWriter, thriftType byte) error {
+	switch thriftType {
+	case TypeBool:
+		v, err := r.ReadBool()
+		if err != nil {
+			return err
+		}
+		return w.WriteBool(v)
+	case TypeByte:
+		v, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return w.WriteByte(v)
+	case TypeI16:
+		v, err := r.ReadI16()
+		if err != nil {
+			return err
+		}
+		return w.WriteI16(v)
+	case TypeI32:
+		v, err := r.ReadI32()
+		if err != nil {
+			return err
+		}
+		return w.WriteI32(v)
+	case TypeI64:
+		v, err := r.ReadI64()
+		if err != nil {
+			return err
+		}
+		return w.WriteI64(v)
+	case TypeDouble:
+		v, err := r.ReadDouble()
+		if err != nil {
+			return err
+		}
+		return w.WriteDouble(v)
+	case TypeString:
+		// Strings and binary share a type but not every protocol encodes
+		// them the same way. Reading binary as a string keeps e.g. JSON's
+		// base64 as is so it's only lossless when writing with the same
+		// protocol.
+		v, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		return w.WriteString(v)
+	case TypeStruct:
+		if err := r.ReadStructBegin(); err != nil {
+			return err
+		}
+		if err := w.WriteStructBegin(""); err != nil {
+			return err
+		}
+		for {
+			ftype, id, err := r.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if ftype == TypeStop {
+				break
+			}
+			if err := w.WriteFieldBegin("", ftype, id); err != nil {
+				return err
+			}
+			if err := copyValue(r, w, ftype); err != nil {
+				return err
+			}
+			if err := r.ReadFieldEnd(); err != nil {
+				return err
+			}
+			if err := w.WriteFieldEnd(); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadStructEnd(); err != nil {
+			return err
+		}
+		if err := w.WriteFieldStop(); err != nil {
+			return err
+		}
+		return w.WriteStructEnd()
+	case TypeMap:
+		keyType, valueType, n, err := r.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+		if err := w.WriteMapBegin(keyType, valueType, n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := copyValue(r, w, keyType); err != nil {
+				return err
+			}
+			if err := copyValue(r, w, valueType); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadMapEnd(); err != nil {
+			return err
+		}
+		return w.WriteMapEnd()
+	case TypeList:
+		elemType, n, err := r.ReadListBegin()
+		if err != nil {
+			return err
+		}
+		if err := w.WriteListBegin(elemType, n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := copyValue(r, w, elemType); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadListEnd(); err != nil {
+			return err
+		}
+		return w.WriteListEnd()
+	case TypeSet:
+		elemType, n, err := r.ReadSetBegin()
+		if err != nil {
+			return err
+		}
+		if err := w.WriteSetBegin(elemType, n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := copyValue(r, w, elemType); err != nil {
+				return err
+			}
+		}
+		if err := r.ReadSetEnd(); err != nil {
+			return err
+		}
+		return w.WriteSetEnd()
+	}
+	return ProtocolError{"Thrift", fmt.Sprintf("unknown type %d", thriftType)}
+}
@@ -0,0 +1,137 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testUnknownV2 struct {
+	Bool   bool                         `thrift:"1"`
+	Str    string                       `thrift:"2"`
+	List   []*TestStruct2               `thrift:"3"`
+	I64    int64                        `thrift:"4"`
+	Map    map[string][]int32           `thrift:"5"`
+	Nested *testUnknownV2               `thrift:"6"`
+	Set    map[int16]struct{}           `thrift:"8"`
+	Binary []byte                       `thrift:"9"`
+	Double float64                      `thrift:"10"`
+	Maps   []map[int32]*TestEmptyStruct `thrift:"11"`
+}
+
+type testUnknownV1 struct {
+	Str     string        `thrift:"2"`
+	I64     int64         `thrift:"4"`
+	Unknown UnknownFields `thrift:"-,unknown"`
+}
+
+func TestUnknownFields(t *testing.T) {
+	v2 := &testUnknownV2{
+		Bool:   true,
+		Str:    "str",
+		List:   []*TestStruct2{{Str: "a", Binary: []byte{1}}, {Str: "b"}},
+		I64:    -1234,
+		Map:    map[string][]int32{"a": {1, 2, 3}},
+		Nested: &testUnknownV2{Bool: true, I64: 1},
+		Set:    map[int16]struct{}{7: {}},
+		Binary: []byte{0, 0xff, 1},
+		Double: 1.5,
+		Maps:   []map[int32]*TestEmptyStruct{{1: {}}},
+	}
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		data, err := Marshal(v2, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v1 := &testUnknownV1{}
+		if err := Unmarshal(data, v1, p); err != nil {
+			t.Fatalf("Unmarshal returned error: %+v", err)
+		}
+		if v1.Str != v2.Str || v1.I64 != v2.I64 || len(v1.Unknown) != 8 {
+			t.Fatalf("Unmarshal returned %+v", v1)
+		}
+
+		data2, err := Marshal(v1, p)
+		if err != nil {
+			t.Fatalf("Marshal returned error: %+v", err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Fatalf("Expected the same encoding after a round trip:\n%x\n%x", data, data2)
+		}
+		v2b := &testUnknownV2{}
+		if err := Unmarshal(data2, v2b, p); err != nil {
+			t.Fatalf("Unmarshal returned error: %+v", err)
+		}
+		if !reflect.DeepEqual(v2, v2b) {
+			t.Fatalf("Expected %+v instead of %+v", v2, v2b)
+		}
+
+		// Decoding again replaces the previous unknown fields
+		if err := Unmarshal(data, v1, p); err != nil {
+			t.Fatal(err)
+		}
+		if len(v1.Unknown) != 8 {
+			t.Fatalf("Expected 8 unknown fields instead of %d", len(v1.Unknown))
+		}
+	}
+}
+
+func TestUnknownFieldsTranscode(t *testing.T) {
+	v2 := &testUnknownV2{Bool: true, Str: "str", I64: 1, Map: map[string][]int32{"a": {1}}}
+	data, err := Marshal(v2, CompactProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := &testUnknownV1{}
+	if err := Unmarshal(data, v1, CompactProtocol); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown fields can be written with a different protocol and in any
+	// order
+	v1.Unknown[0], v1.Unknown[1] = v1.Unknown[1], v1.Unknown[0]
+	data, err = Marshal(v1, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2b := &testUnknownV2{}
+	if err := Unmarshal(data, v2b, BinaryProtocol); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v2, v2b) {
+		t.Fatalf("Expected %+v instead of %+v", v2, v2b)
+	}
+	expected, _ := Marshal(v2, BinaryProtocol)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected unknown fields to be written in order:\n%x\n%x", expected, data)
+	}
+}
+
+func TestUnknownFieldsKnownID(t *testing.T) {
+	v2 := &testUnknownV2{Str: "old", I64: 2, Bool: true}
+	data, err := Marshal(v2, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := &testUnknownV1{}
+	if err := Unmarshal(data, v1, BinaryProtocol); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown fields with the ID of a known field aren't written
+	v1.Str = "new"
+	v1.Unknown = append(v1.Unknown, UnknownField{ID: 2, Type: TypeString, Value: []byte{0, 0, 0, 3, 'o', 'l', 'd'}})
+	data, err = Marshal(v1, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Marshal(&testUnknownV2{Str: "new", I64: 2, Bool: true}, BinaryProtocol)
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected the known field to be written in place of the unknown one:\n%x\n%x", expected, data)
+	}
+}